@@ -0,0 +1,53 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"github.com/m1tka051209/calculator-service/db"
+	"github.com/m1tka051209/calculator-service/task_manager"
+)
+
+// registerAdminRoutes регистрирует служебные эндпоинты для работы с dead letter.
+// Доступ по заголовку X-Admin-Token; без настроенного токена эндпоинты недоступны.
+func registerAdminRoutes(mux *http.ServeMux, repo db.Repository, tm task_manager.TaskManagerInterface, adminToken string) {
+	// Задачи, исчерпавшие попытки
+	mux.HandleFunc("GET /api/v1/admin/dead-tasks", requireAdmin(adminToken, func(w http.ResponseWriter, r *http.Request) {
+		tasks, err := repo.GetDeadTasks(r.Context())
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]interface{}{"tasks": tasks})
+	}))
+
+	// Повторная постановка задачи в очередь
+	mux.HandleFunc("POST /api/v1/admin/dead-tasks/{id}/requeue", requireAdmin(adminToken, func(w http.ResponseWriter, r *http.Request) {
+		err := tm.RequeueDeadTask(r.Context(), r.PathValue("id"))
+		if errors.Is(err, db.ErrTaskNotFound) {
+			respondJSON(w, http.StatusNotFound, map[string]string{"error": "dead task not found"})
+			return
+		}
+		if errors.Is(err, db.ErrRequeueBlocked) {
+			respondJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]string{"status": "pending"})
+	}))
+}
+
+func requireAdmin(adminToken string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("X-Admin-Token")
+		if adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			respondJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
+			return
+		}
+		next(w, r)
+	}
+}
@@ -1,27 +1,55 @@
 package api
 
 import (
+	"errors"
 	"time"
+
 	"github.com/golang-jwt/jwt/v5"
 )
 
-const jwtSecret = "your-256-bit-secret"
+// jwtSecret ключ подписи токенов пользователей, задается SetJWTSecret
+var jwtSecret []byte
+
+// errNoJWTSecret возвращается, пока ключ подписи не задан: подписывать токены
+// известным всем ключом нельзя, иначе любой подделает токен чужого пользователя
+var errNoJWTSecret = errors.New("JWT secret is not set")
+
+// SetJWTSecret задает ключ подписи токенов; вызывается до запуска шлюза
+func SetJWTSecret(secret string) error {
+	if secret == "" {
+		return errNoJWTSecret
+	}
+	jwtSecret = []byte(secret)
+	return nil
+}
 
 func GenerateJWT(userID string) (string, error) {
+	if len(jwtSecret) == 0 {
+		return "", errNoJWTSecret
+	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"sub": userID,
 		"exp": time.Now().Add(24 * time.Hour).Unix(),
 	})
-	return token.SignedString([]byte(jwtSecret))
+	return token.SignedString(jwtSecret)
 }
 
 func ValidateJWT(tokenString string) (string, error) {
+	if len(jwtSecret) == 0 {
+		return "", errNoJWTSecret
+	}
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte(jwtSecret), nil
-	})
+		return jwtSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return "", err
+	}
+	sub, err := token.Claims.GetSubject()
 	if err != nil {
 		return "", err
 	}
-	claims := token.Claims.(jwt.MapClaims)
-	return claims["sub"].(string), nil
+	if sub == "" {
+		return "", errors.New("token has no subject")
+	}
+	return sub, nil
 }
\ No newline at end of file
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/m1tka051209/calculator-service/db"
+	"github.com/m1tka051209/calculator-service/task_manager"
+	"golang.org/x/net/websocket"
+)
+
+// sseKeepAlive как часто в молчащий SSE-поток пишется комментарий, чтобы прокси не закрыли соединение
+var sseKeepAlive = 15 * time.Second
+
+// registerEventRoutes регистрирует потоковые эндпоинты с событиями выражений.
+// Браузерные EventSource и WebSocket не умеют передавать заголовки, поэтому токен
+// здесь принимается и в параметре ?token=.
+func registerEventRoutes(mux *http.ServeMux, repo db.Repository, tm task_manager.TaskManagerInterface) {
+	// События одного выражения (Server-Sent Events)
+	mux.HandleFunc("GET /api/v1/expressions/{id}/events", requireStreamUser(func(w http.ResponseWriter, r *http.Request, userID string) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "streaming unsupported"})
+			return
+		}
+
+		// Подписка до чтения состояния, чтобы не пропустить переход между ними
+		sub := tm.Events().SubscribeExpression(r.PathValue("id"))
+		defer sub.Close()
+
+		expr, err := repo.GetExpression(r.Context(), r.PathValue("id"))
+		if errors.Is(err, db.ErrExpressionNotFound) || (err == nil && expr.UserID != userID) {
+			respondJSON(w, http.StatusNotFound, map[string]string{"error": "expression not found"})
+			return
+		}
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+
+		ev := task_manager.ExpressionEvent(expr)
+		if err := writeSSE(w, ev); err != nil || ev.Terminal() {
+			return
+		}
+		flusher.Flush()
+
+		keepAlive := time.NewTicker(sseKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+			case ev, ok := <-sub.C:
+				if !ok {
+					// Отстали от событий: клиент переподключится и получит актуальное состояние
+					return
+				}
+				if err := writeSSE(w, ev); err != nil {
+					return
+				}
+				if ev.Terminal() {
+					flusher.Flush()
+					return
+				}
+			}
+			flusher.Flush()
+		}
+	}))
+
+	// События всех выражений пользователя (WebSocket)
+	mux.HandleFunc("GET /api/v1/ws", requireStreamUser(func(w http.ResponseWriter, r *http.Request, userID string) {
+		sub := tm.Events().SubscribeUser(userID)
+		defer sub.Close()
+
+		// websocket.Server без Handshake не проверяет Origin: доступ определяет токен, а не cookie
+		websocket.Server{Handler: func(ws *websocket.Conn) {
+			closed := make(chan struct{})
+			go func() {
+				// Входящие сообщения не нужны, чтение только ловит закрытие соединения
+				defer close(closed)
+				var msg string
+				for websocket.Message.Receive(ws, &msg) == nil {
+				}
+			}()
+
+			for {
+				select {
+				case <-closed:
+					return
+				case ev, ok := <-sub.C:
+					if !ok {
+						return
+					}
+					if err := websocket.JSON.Send(ws, ev); err != nil {
+						log.Printf("Error sending event to user %s: %v", userID, err)
+						return
+					}
+				}
+			}
+		}}.ServeHTTP(w, r)
+	}))
+}
+
+func writeSSE(w http.ResponseWriter, ev task_manager.Event) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+	return err
+}
+
+// requireStreamUser как requireUser, но принимает токен и из параметра ?token=
+func requireStreamUser(next func(w http.ResponseWriter, r *http.Request, userID string)) http.HandlerFunc {
+	return authenticated(next, true)
+}
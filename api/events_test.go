@@ -0,0 +1,118 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/m1tka051209/calculator-service/task_manager"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+// createTestExpression отправляет выражение от имени пользователя и возвращает его ID
+func createTestExpression(t *testing.T, srv *testGateway, token, expr string) string {
+	t.Helper()
+	var created struct {
+		ExpressionID string `json:"expression_id"`
+	}
+	require.Equal(t, http.StatusAccepted,
+		doJSON(t, "POST", srv.URL+"/api/v1/calculate", token, map[string]string{"expression": expr}, &created))
+	return created.ExpressionID
+}
+
+// completeNextTask выполняет следующую готовую задачу с заданным результатом
+func completeNextTask(t *testing.T, srv *testGateway, result float64) {
+	t.Helper()
+	task, err := srv.tm.GetNextTask("w1")
+	require.NoError(t, err)
+	require.NotNil(t, task)
+	require.NoError(t, srv.tm.UpdateTaskResult(context.Background(), task.ID, "w1", result))
+}
+
+// readSSE читает события SSE-потока до его закрытия
+func readSSE(t *testing.T, resp *http.Response) []task_manager.Event {
+	t.Helper()
+	var events []task_manager.Event
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var ev task_manager.Event
+		require.NoError(t, json.Unmarshal([]byte(data), &ev))
+		events = append(events, ev)
+	}
+	return events
+}
+
+func TestExpressionEventsSSE(t *testing.T) {
+	srv := newTestGateway(t)
+	token := loginTestUser(t, srv, "alice")
+	id := createTestExpression(t, srv, token, "1+2")
+
+	req, err := http.NewRequest("GET", srv.URL+"/api/v1/expressions/"+id+"/events?token="+token, nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	completeNextTask(t, srv, 3)
+
+	events := readSSE(t, resp)
+	require.Len(t, events, 5)
+	assert.Equal(t, "pending", events[0].Status)
+	assert.Equal(t, task_manager.EventTask, events[1].Type)
+	assert.Equal(t, "processing", events[1].Status)
+	assert.Equal(t, "processing", events[2].Status)
+	assert.Equal(t, task_manager.EventTask, events[3].Type)
+	assert.Equal(t, "completed", events[3].Status)
+	assert.True(t, events[4].Terminal())
+	require.NotNil(t, events[4].Result)
+	assert.Equal(t, 3.0, *events[4].Result)
+}
+
+func TestExpressionEventsForbidden(t *testing.T) {
+	srv := newTestGateway(t)
+	id := createTestExpression(t, srv, loginTestUser(t, srv, "alice"), "1+2")
+	bob := loginTestUser(t, srv, "bob")
+
+	assert.Equal(t, http.StatusNotFound, doJSON(t, "GET", srv.URL+"/api/v1/expressions/"+id+"/events", bob, nil, nil))
+	assert.Equal(t, http.StatusUnauthorized, doJSON(t, "GET", srv.URL+"/api/v1/expressions/"+id+"/events", "", nil, nil))
+}
+
+func TestUserEventsWebSocket(t *testing.T) {
+	srv := newTestGateway(t)
+	token := loginTestUser(t, srv, "alice")
+
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/api/v1/ws?token=" + token
+	ws, err := websocket.Dial(wsURL, "", srv.URL)
+	require.NoError(t, err)
+	defer ws.Close()
+
+	id := createTestExpression(t, srv, token, "2*3")
+	completeNextTask(t, srv, 6)
+
+	var statuses []string
+	for {
+		var ev task_manager.Event
+		require.NoError(t, websocket.JSON.Receive(ws, &ev))
+		assert.Equal(t, id, ev.ExpressionID)
+		statuses = append(statuses, ev.Type+":"+ev.Status)
+		if ev.Terminal() {
+			require.NotNil(t, ev.Result)
+			assert.Equal(t, 6.0, *ev.Result)
+			break
+		}
+	}
+	assert.Equal(t, []string{
+		"expression:pending", "task:processing", "expression:processing", "task:completed", "expression:completed",
+	}, statuses)
+}
@@ -0,0 +1,255 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/m1tka051209/calculator-service/calculator"
+	"github.com/m1tka051209/calculator-service/db"
+	"github.com/m1tka051209/calculator-service/models"
+	"github.com/m1tka051209/calculator-service/task_manager"
+)
+
+// taskNode задача выражения вместе с задачами, вычисляющими ее аргументы
+type taskNode struct {
+	models.Task
+	Arg1Task *taskNode `json:"arg1_task,omitempty"`
+	Arg2Task *taskNode `json:"arg2_task,omitempty"`
+	// ArgTasks задачи аргументов вызова функции; nil на месте аргумента-числа
+	ArgTasks []*taskNode `json:"arg_tasks,omitempty"`
+}
+
+// expressionDetail выражение и дерево его задач; корень дерева вычисляет значение выражения
+type expressionDetail struct {
+	models.Expression
+	Tasks *taskNode `json:"tasks,omitempty"`
+	// WebhookAttempts попытки доставки выражения на его callback URL
+	WebhookAttempts []models.WebhookAttempt `json:"webhook_attempts,omitempty"`
+}
+
+// registerExpressionRoutes регистрирует эндпоинты для чтения отдельного выражения.
+// С параметром ?wait=30s ответ задерживается, пока выражение не завершится или не
+// истечет время; ожидание идет по событиям менеджера задач, а не опросом базы.
+func registerExpressionRoutes(mux *http.ServeMux, repo db.Repository, tm task_manager.TaskManagerInterface) {
+	// Выражение с разбивкой на задачи
+	mux.HandleFunc("GET /api/v1/expressions/{id}", requireUser(func(w http.ResponseWriter, r *http.Request, userID string) {
+		wait, err := parseWait(r)
+		if err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		var sub *task_manager.Subscription
+		if wait > 0 {
+			// Подписка до чтения, чтобы не пропустить завершение между ними
+			sub = tm.Events().SubscribeExpression(r.PathValue("id"))
+			defer sub.Close()
+		}
+
+		expr, err := repo.GetExpression(r.Context(), r.PathValue("id"))
+		// Чужое выражение неотличимо от несуществующего
+		if errors.Is(err, db.ErrExpressionNotFound) || (err == nil && expr.UserID != userID) {
+			respondJSON(w, http.StatusNotFound, map[string]string{"error": "expression not found"})
+			return
+		}
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+			return
+		}
+
+		if sub != nil && unfinished(*expr) {
+			waitFinished(r.Context(), sub, wait)
+			expr, err = repo.GetExpression(r.Context(), expr.ID)
+			if err != nil {
+				respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+				return
+			}
+		}
+
+		tasks, err := repo.GetExpressionTasks(r.Context(), expr.ID)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+			return
+		}
+		detail := expressionDetail{Expression: *expr, Tasks: buildTaskTree(tasks)}
+		if expr.CallbackURL != "" {
+			detail.WebhookAttempts, err = repo.GetWebhookAttempts(r.Context(), expr.ID)
+			if err != nil {
+				respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+				return
+			}
+		}
+		respondJSON(w, http.StatusOK, detail)
+	}))
+}
+
+// defaultEvaluateTimeout сколько POST /api/v1/evaluate ждет результат, если timeout не задан
+const defaultEvaluateTimeout = 5 * time.Second
+
+// maxWait верхняя граница параметра ?wait= и timeout синхронного вычисления
+const maxWait = 2 * time.Minute
+
+// registerEvaluateRoute регистрирует синхронное вычисление: выражение ставится в
+// ту же очередь, что и через /calculate, но ответ ждет результата до timeout.
+// Не успевшее выражение продолжает вычисляться, клиент получает 202 и его ID.
+func registerEvaluateRoute(mux *http.ServeMux, repo db.Repository, tm task_manager.TaskManagerInterface) {
+	mux.HandleFunc("POST /api/v1/evaluate", requireUser(func(w http.ResponseWriter, r *http.Request, userID string) {
+		var req struct {
+			Expression string `json:"expression"`
+			// Timeout сколько ждать результат, например "2s"
+			Timeout string `json:"timeout"`
+			precisionFields
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+			return
+		}
+		timeout := defaultEvaluateTimeout
+		if req.Timeout != "" {
+			d, err := time.ParseDuration(req.Timeout)
+			if err != nil || d <= 0 {
+				respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid timeout: expected a duration such as 2s"})
+				return
+			}
+			timeout = min(d, maxWait)
+		}
+
+		// ID назначается заранее, чтобы подписаться до постановки задач в очередь
+		expr := &models.Expression{ID: uuid.New().String(), UserID: userID, Expression: req.Expression}
+		req.apply(expr)
+		sub := tm.Events().SubscribeExpression(expr.ID)
+		defer sub.Close()
+
+		_, err := tm.SubmitExpression(r.Context(), expr)
+		if errors.Is(err, calculator.ErrInvalidPrecision) {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, calculator.ErrInvalidExpression) {
+			respondInvalidExpression(w, err)
+			return
+		}
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+			return
+		}
+
+		if unfinished(*expr) {
+			waitFinished(r.Context(), sub, timeout)
+		}
+		// Выражение перечитывается и тогда, когда оно завершилось при отправке:
+		// created_at и другие поля заполняет база
+		if expr, err = repo.GetExpression(r.Context(), expr.ID); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+			return
+		}
+		if unfinished(*expr) {
+			respondJSON(w, http.StatusAccepted, map[string]string{
+				"expression_id": expr.ID,
+				"status":        expr.Status,
+			})
+			return
+		}
+		respondJSON(w, http.StatusOK, expr)
+	}))
+}
+
+// precisionFields поля запроса, выбирающие режим точности выражения:
+// "precision": "decimal" с необязательными "scale" и "rounding"
+type precisionFields struct {
+	Precision string `json:"precision"`
+	Scale     *int   `json:"scale"`
+	Rounding  string `json:"rounding"`
+}
+
+// apply переносит режим точности в выражение; проверяет его SubmitExpression
+func (p precisionFields) apply(expr *models.Expression) {
+	expr.Precision = p.Precision
+	expr.Scale = p.Scale
+	expr.Rounding = p.Rounding
+}
+
+// parseWait читает параметр ?wait= (например 30s); без него возвращает 0
+func parseWait(r *http.Request) (time.Duration, error) {
+	raw := r.URL.Query().Get("wait")
+	if raw == "" {
+		return 0, nil
+	}
+	wait, err := time.ParseDuration(raw)
+	if err != nil || wait < 0 {
+		return 0, errors.New("invalid wait: expected a duration such as 30s")
+	}
+	return min(wait, maxWait), nil
+}
+
+// unfinished сообщает, что выражение еще вычисляется
+func unfinished(expr models.Expression) bool {
+	return expr.Status == "pending" || expr.Status == "processing"
+}
+
+// waitFinished ждет события о завершении выражения из sub не дольше wait.
+// Возвращает true, если такое событие пришло; false по истечении wait, отмене
+// запроса или если подписка закрылась из-за отставания.
+func waitFinished(ctx context.Context, sub *task_manager.Subscription, wait time.Duration) bool {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+			return false
+		case ev, ok := <-sub.C:
+			if !ok {
+				return false
+			}
+			if ev.Terminal() {
+				return true
+			}
+		}
+	}
+}
+
+// buildTaskTree собирает задачи в дерево по ссылкам на задачи-аргументы.
+// Возвращает корневую задачу, от которой не зависит ни одна другая, или nil, если задач нет.
+func buildTaskTree(tasks []models.Task) *taskNode {
+	nodes := make(map[string]*taskNode, len(tasks))
+	for i := range tasks {
+		nodes[tasks[i].ID] = &taskNode{Task: tasks[i]}
+	}
+
+	isArg := make(map[string]bool, len(tasks))
+	for _, n := range nodes {
+		if n.Arg1TaskID != "" {
+			n.Arg1Task = nodes[n.Arg1TaskID]
+			isArg[n.Arg1TaskID] = true
+		}
+		if n.Arg2TaskID != "" {
+			n.Arg2Task = nodes[n.Arg2TaskID]
+			isArg[n.Arg2TaskID] = true
+		}
+		for _, id := range n.ArgTaskIDs {
+			if id != "" {
+				isArg[id] = true
+			}
+		}
+		if slices.ContainsFunc(n.ArgTaskIDs, func(id string) bool { return id != "" }) {
+			n.ArgTasks = make([]*taskNode, len(n.ArgTaskIDs))
+			for i, id := range n.ArgTaskIDs {
+				n.ArgTasks[i] = nodes[id]
+			}
+		}
+	}
+
+	for i := range tasks {
+		if !isArg[tasks[i].ID] {
+			return nodes[tasks[i].ID]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/m1tka051209/calculator-service/calculator"
+	"github.com/m1tka051209/calculator-service/db"
+	"github.com/m1tka051209/calculator-service/models"
+)
+
+// functionResponse функция пользователя вместе с ее определением одной строкой
+type functionResponse struct {
+	models.UserFunction
+	Definition string `json:"definition"`
+}
+
+func newFunctionResponse(fn *models.UserFunction) functionResponse {
+	return functionResponse{UserFunction: *fn, Definition: fn.Definition()}
+}
+
+// registerFunctionRoutes регистрирует эндпоинты пользовательских функций. Вызовы
+// функций подставляются их телами при отправке выражения, так что воркеры видят
+// только операторы и встроенные функции.
+func registerFunctionRoutes(mux *http.ServeMux, repo db.Repository) {
+	// Функции пользователя и имена встроенных функций
+	mux.HandleFunc("GET /api/v1/functions", requireUser(func(w http.ResponseWriter, r *http.Request, userID string) {
+		fns, err := repo.GetFunctions(r.Context(), userID)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+			return
+		}
+		out := make([]functionResponse, len(fns))
+		for i := range fns {
+			out[i] = newFunctionResponse(&fns[i])
+		}
+		respondJSON(w, http.StatusOK, map[string]interface{}{"functions": out, "builtins": calculator.FunctionNames()})
+	}))
+
+	// Создание или замена функции по определению вида "vat(x) = x * 0.2"
+	mux.HandleFunc("POST /api/v1/functions", requireUser(func(w http.ResponseWriter, r *http.Request, userID string) {
+		var req struct {
+			Definition string `json:"definition"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+			return
+		}
+		fn, err := calculator.ParseDefinition(req.Definition)
+		if err != nil {
+			respondJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+			return
+		}
+		fn.UserID = userID
+		if err := repo.SetFunction(r.Context(), fn); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+			return
+		}
+		respondJSON(w, http.StatusOK, newFunctionResponse(fn))
+	}))
+
+	mux.HandleFunc("GET /api/v1/functions/{name}", requireUser(func(w http.ResponseWriter, r *http.Request, userID string) {
+		fn, err := repo.GetFunction(r.Context(), userID, r.PathValue("name"))
+		if errors.Is(err, db.ErrFunctionNotFound) {
+			respondJSON(w, http.StatusNotFound, map[string]string{"error": "function not found"})
+			return
+		}
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+			return
+		}
+		respondJSON(w, http.StatusOK, newFunctionResponse(fn))
+	}))
+
+	mux.HandleFunc("DELETE /api/v1/functions/{name}", requireUser(func(w http.ResponseWriter, r *http.Request, userID string) {
+		err := repo.DeleteFunction(r.Context(), userID, r.PathValue("name"))
+		if errors.Is(err, db.ErrFunctionNotFound) {
+			respondJSON(w, http.StatusNotFound, map[string]string{"error": "function not found"})
+			return
+		}
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+	}))
+}
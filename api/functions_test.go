@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/m1tka051209/calculator-service/calculator"
+	"github.com/m1tka051209/calculator-service/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFunctions(t *testing.T) {
+	srv := newTestGateway(t)
+	alice := loginTestUser(t, srv, "alice")
+	bob := loginTestUser(t, srv, "bob")
+
+	var fn functionResponse
+	require.Equal(t, http.StatusOK,
+		doJSON(t, "POST", srv.URL+"/api/v1/functions", alice, map[string]string{"definition": "sq(x) = x*x"}, &fn))
+	assert.Equal(t, "sq", fn.Name)
+	assert.Equal(t, []string{"x"}, fn.Params)
+	assert.Equal(t, "sq(x) = x*x", fn.Definition)
+	require.Equal(t, http.StatusOK,
+		doJSON(t, "POST", srv.URL+"/api/v1/functions", alice, map[string]string{"definition": "hyp(a, b) = sqrt(sq(a) + sq(b))"}, nil))
+
+	var list struct {
+		Functions []functionResponse `json:"functions"`
+		Builtins  []string           `json:"builtins"`
+	}
+	require.Equal(t, http.StatusOK, doJSON(t, "GET", srv.URL+"/api/v1/functions", alice, nil, &list))
+	assert.Len(t, list.Functions, 2)
+	assert.Contains(t, list.Builtins, "sqrt")
+
+	var created struct {
+		ExpressionID string `json:"expression_id"`
+	}
+	require.Equal(t, http.StatusAccepted,
+		doJSON(t, "POST", srv.URL+"/api/v1/calculate", alice, map[string]string{"expression": "hyp(1+2, 4)"}, &created))
+
+	// Воркеры получают только операторы и встроенные функции; 1+2 считается один раз
+	var ops []string
+	for {
+		task, err := srv.tm.GetNextTask("w1")
+		require.NoError(t, err)
+		if task == nil {
+			break
+		}
+		ops = append(ops, task.Operation)
+		result, err := calculator.Calculate(context.Background(), task)
+		require.NoError(t, err)
+		require.NoError(t, srv.tm.UpdateTaskResult(context.Background(), task.ID, "w1", result))
+	}
+	assert.ElementsMatch(t, []string{"+", "*", "*", "+", "sqrt"}, ops)
+
+	var expr models.Expression
+	require.Equal(t, http.StatusOK,
+		doJSON(t, "GET", srv.URL+"/api/v1/expressions/"+created.ExpressionID, alice, nil, &expr))
+	assert.Equal(t, "completed", expr.Status)
+	require.NotNil(t, expr.Result)
+	assert.Equal(t, 5.0, *expr.Result)
+	assert.Equal(t, map[string]string{"sq": "sq(x) = x*x", "hyp": "hyp(a, b) = sqrt(sq(a) + sq(b))"}, expr.Functions)
+
+	assert.Equal(t, http.StatusUnprocessableEntity,
+		doJSON(t, "POST", srv.URL+"/api/v1/calculate", bob, map[string]string{"expression": "sq(2)"}, nil))
+	assert.Equal(t, http.StatusNotFound, doJSON(t, "GET", srv.URL+"/api/v1/functions/sq", bob, nil, nil))
+
+	for _, def := range []string{"f(x) = f(x)", "sqrt(x) = x", "f(x) = x +", "f x = x"} {
+		assert.Equal(t, http.StatusUnprocessableEntity,
+			doJSON(t, "POST", srv.URL+"/api/v1/functions", alice, map[string]string{"definition": def}, nil), def)
+	}
+
+	// Взаимная рекурсия обнаруживается при отправке выражения
+	require.Equal(t, http.StatusOK,
+		doJSON(t, "POST", srv.URL+"/api/v1/functions", alice, map[string]string{"definition": "ping(x) = pong(x)"}, nil))
+	require.Equal(t, http.StatusOK,
+		doJSON(t, "POST", srv.URL+"/api/v1/functions", alice, map[string]string{"definition": "pong(x) = ping(x)"}, nil))
+	assert.Equal(t, http.StatusUnprocessableEntity,
+		doJSON(t, "POST", srv.URL+"/api/v1/calculate", alice, map[string]string{"expression": "ping(1)"}, nil))
+
+	require.Equal(t, http.StatusOK, doJSON(t, "DELETE", srv.URL+"/api/v1/functions/sq", alice, nil, nil))
+	assert.Equal(t, http.StatusNotFound, doJSON(t, "GET", srv.URL+"/api/v1/functions/sq", alice, nil, nil))
+	assert.Equal(t, http.StatusUnprocessableEntity,
+		doJSON(t, "POST", srv.URL+"/api/v1/calculate", alice, map[string]string{"expression": "hyp(3, 4)"}, nil))
+}
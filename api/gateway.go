@@ -2,11 +2,22 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"slices"
 	"strings"
+	"time"
+
+	"github.com/m1tka051209/calculator-service/calculator"
+	"github.com/m1tka051209/calculator-service/db"
+	"github.com/m1tka051209/calculator-service/models"
+	"github.com/m1tka051209/calculator-service/task_manager"
+	"github.com/m1tka051209/calculator-service/webhook"
 )
 
-func StartHTTPGateway() http.Handler {
+// StartHTTPGateway собирает REST API поверх репозитория и менеджера задач.
+// Выражения создаются и читаются от имени пользователя из JWT.
+func StartHTTPGateway(repo db.Repository, tm task_manager.TaskManagerInterface, adminToken string) http.Handler {
 	mux := http.NewServeMux()
 
 	// Регистрация
@@ -20,7 +31,19 @@ func StartHTTPGateway() http.Handler {
 			return
 		}
 
-		// Здесь должна быть логика регистрации через ваш репозиторий
+		err := registerUser(r.Context(), repo, req.Login, req.Password)
+		var policyErr *PolicyError
+		switch {
+		case errors.As(err, &policyErr):
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": policyErr.Reason})
+			return
+		case errors.Is(err, db.ErrUserExists):
+			respondJSON(w, http.StatusConflict, map[string]string{"error": "login already taken"})
+			return
+		case err != nil:
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+			return
+		}
 		respondJSON(w, http.StatusOK, map[string]string{"status": "OK"})
 	})
 
@@ -35,60 +58,167 @@ func StartHTTPGateway() http.Handler {
 			return
 		}
 
-		// Здесь должна быть проверка логина/пароля
-		token, _ := GenerateJWT("user123") // Замените на реальный ID
-		respondJSON(w, http.StatusOK, map[string]string{"token": token})
-	})
+		userID, err := authenticate(r.Context(), repo, req.Login, req.Password)
+		if errors.Is(err, ErrInvalidCredentials) {
+			respondJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+			return
+		}
 
-	// Вычисление выражения
-	mux.HandleFunc("POST /api/v1/calculate", func(w http.ResponseWriter, r *http.Request) {
-		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
-		if _, err := ValidateJWT(token); err != nil {
-			respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		token, err := GenerateJWT(userID)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
 			return
 		}
+		respondJSON(w, http.StatusOK, map[string]string{"token": token})
+	})
 
+	// Вычисление выражения
+	mux.HandleFunc("POST /api/v1/calculate", requireUser(func(w http.ResponseWriter, r *http.Request, userID string) {
 		var req struct {
 			Expression string `json:"expression"`
+			// CallbackURL необязательный адрес, на который придет выражение после завершения
+			CallbackURL string `json:"callback_url"`
+			precisionFields
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
 			return
 		}
+		if req.CallbackURL != "" {
+			if err := webhook.ValidateURL(r.Context(), req.CallbackURL); err != nil {
+				respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+		}
 
-		// Здесь вызов вашего gRPC сервиса
-		respondJSON(w, http.StatusAccepted, map[string]string{
-			"expression_id": "generated-id-123",
-			"status":        "pending",
+		expr := &models.Expression{UserID: userID, Expression: req.Expression, CallbackURL: req.CallbackURL}
+		req.apply(expr)
+		exprID, err := tm.SubmitExpression(r.Context(), expr)
+		if errors.Is(err, calculator.ErrInvalidPrecision) {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, calculator.ErrInvalidExpression) {
+			respondInvalidExpression(w, err)
+			return
+		}
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+			return
+		}
+		// Выражение, которое упрощения или отсутствие операций (например "7")
+		// завершили при отправке, возвращается с кодом 200 и уже с результатом
+		status := http.StatusAccepted
+		if !unfinished(*expr) {
+			status = http.StatusOK
+		}
+		respondJSON(w, status, calculateResponse{
+			ExpressionID:  exprID,
+			Status:        expr.Status,
+			Result:        expr.Result,
+			ExactResult:   expr.ExactResult,
+			DecimalResult: expr.DecimalResult,
 		})
-	})
+	}))
 
 	// Получение выражений
-	mux.HandleFunc("GET /api/v1/expressions", func(w http.ResponseWriter, r *http.Request) {
-		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
-		if _, err := ValidateJWT(token); err != nil {
-			respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	// С ?wait=30s ответ задерживается, пока все выражения пользователя не завершатся
+	mux.HandleFunc("GET /api/v1/expressions", requireUser(func(w http.ResponseWriter, r *http.Request, userID string) {
+		wait, err := parseWait(r)
+		if err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 			return
 		}
+		var sub *task_manager.Subscription
+		if wait > 0 {
+			sub = tm.Events().SubscribeUser(userID)
+			defer sub.Close()
+		}
+		deadline := time.Now().Add(wait)
 
-		// Здесь получение выражений из репозитория
-		respondJSON(w, http.StatusOK, []map[string]interface{}{
-			{
-				"id":          "generated-id-123",
-				"expression":  "2+2*2",
-				"status":      "completed",
-				"result":      6,
-				"created_at":  "2025-05-09T16:20:00Z",
-				"completed_at": "2025-05-09T16:20:05Z",
-			},
-		})
-	})
+		exprs, err := repo.GetExpressionsByUser(r.Context(), userID)
+		for err == nil && sub != nil && slices.ContainsFunc(exprs, unfinished) {
+			// Список перечитывается только после завершения какого-нибудь выражения
+			// и один раз по окончании ожидания
+			done := waitFinished(r.Context(), sub, time.Until(deadline))
+			exprs, err = repo.GetExpressionsByUser(r.Context(), userID)
+			if !done {
+				break
+			}
+		}
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+			return
+		}
+		if exprs == nil {
+			exprs = []models.Expression{}
+		}
+		respondJSON(w, http.StatusOK, exprs)
+	}))
+
+	registerExpressionRoutes(mux, repo, tm)
+	registerEvaluateRoute(mux, repo, tm)
+	registerEventRoutes(mux, repo, tm)
+	registerVariableRoutes(mux, repo)
+	registerFunctionRoutes(mux, repo)
+	registerAdminRoutes(mux, repo, tm, adminToken)
 
 	return mux
 }
 
+// requireUser пропускает запрос только с действительным JWT и передает
+// обработчику ID пользователя из токена
+func requireUser(next func(w http.ResponseWriter, r *http.Request, userID string)) http.HandlerFunc {
+	return authenticated(next, false)
+}
+
+func authenticated(next func(w http.ResponseWriter, r *http.Request, userID string), allowQuery bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" && allowQuery {
+			token = r.URL.Query().Get("token")
+		}
+		userID, err := ValidateJWT(token)
+		if err != nil {
+			respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		next(w, r, userID)
+	}
+}
+
 func respondJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(data)
+}
+
+// calculateResponse ответ на отправку выражения; результат есть, только если
+// выражение завершилось при отправке
+type calculateResponse struct {
+	ExpressionID  string   `json:"expression_id"`
+	Status        string   `json:"status"`
+	Result        *float64 `json:"result,omitempty"`
+	ExactResult   string   `json:"exact_result,omitempty"`
+	DecimalResult string   `json:"decimal_result,omitempty"`
+}
+
+// invalidExpressionResponse ответ на неверное выражение: кроме текста ошибки
+// место ошибки и фрагмент выражения, под которым ^ указывает на нее
+type invalidExpressionResponse struct {
+	Error string `json:"error"`
+	*calculator.ParseError
+	Snippet string `json:"snippet,omitempty"`
+}
+
+func respondInvalidExpression(w http.ResponseWriter, err error) {
+	resp := invalidExpressionResponse{Error: err.Error()}
+	if errors.As(err, &resp.ParseError) {
+		resp.Snippet = resp.ParseError.Snippet()
+	}
+	respondJSON(w, http.StatusUnprocessableEntity, resp)
 }
\ No newline at end of file
@@ -0,0 +1,440 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/m1tka051209/calculator-service/calculator"
+	"github.com/m1tka051209/calculator-service/db"
+	"github.com/m1tka051209/calculator-service/models"
+	"github.com/m1tka051209/calculator-service/task_manager"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testGateway HTTP-сервер шлюза и менеджер задач, через который тесты выполняют задачи вместо агента
+type testGateway struct {
+	*httptest.Server
+	tm *task_manager.TaskManager
+}
+
+func newTestGateway(t *testing.T) *testGateway {
+	t.Helper()
+	return newOptimizingTestGateway(t, calculator.Optimizations{})
+}
+
+// newOptimizingTestGateway как newTestGateway с упрощениями выражений optimize
+func newOptimizingTestGateway(t *testing.T, optimize calculator.Optimizations) *testGateway {
+	t.Helper()
+	require.NoError(t, SetJWTSecret("test-jwt-secret"))
+	repo, err := db.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { repo.Close() })
+
+	tm := task_manager.NewTaskManager(repo, time.Minute, task_manager.RetryPolicy{MaxAttempts: 1}, nil,
+		calculator.DecimalOptions{Scale: 10, Rounding: calculator.RoundHalfEven}, optimize)
+	srv := httptest.NewServer(StartHTTPGateway(repo, tm, ""))
+	t.Cleanup(srv.Close)
+	return &testGateway{Server: srv, tm: tm}
+}
+
+// doJSON отправляет запрос с JSON-телом и декодирует JSON-ответ в out
+func doJSON(t *testing.T, method, url, token string, body, out interface{}) int {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		require.NoError(t, json.NewEncoder(&buf).Encode(body))
+	}
+	req, err := http.NewRequest(method, url, &buf)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	if out != nil {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(out))
+	}
+	return resp.StatusCode
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	srv := newTestGateway(t)
+	creds := map[string]string{"login": "alice", "password": "secret123"}
+
+	assert.Equal(t, http.StatusOK, doJSON(t, "POST", srv.URL+"/api/v1/register", "", creds, nil))
+	assert.Equal(t, http.StatusConflict, doJSON(t, "POST", srv.URL+"/api/v1/register", "", creds, nil))
+
+	var login struct {
+		Token string `json:"token"`
+	}
+	require.Equal(t, http.StatusOK, doJSON(t, "POST", srv.URL+"/api/v1/login", "", creds, &login))
+	userID, err := ValidateJWT(login.Token)
+	require.NoError(t, err)
+	assert.NotEmpty(t, userID)
+	assert.NotEqual(t, "user123", userID)
+
+	wrong := map[string]string{"login": "alice", "password": "wrong1234"}
+	assert.Equal(t, http.StatusUnauthorized, doJSON(t, "POST", srv.URL+"/api/v1/login", "", wrong, nil))
+	unknown := map[string]string{"login": "bob", "password": "secret123"}
+	assert.Equal(t, http.StatusUnauthorized, doJSON(t, "POST", srv.URL+"/api/v1/login", "", unknown, nil))
+}
+
+func TestRegisterPasswordPolicy(t *testing.T) {
+	srv := newTestGateway(t)
+
+	for name, creds := range map[string]map[string]string{
+		"short password": {"login": "alice", "password": "abc1"},
+		"no digits":      {"login": "alice", "password": "password"},
+		"short login":    {"login": "al", "password": "secret123"},
+		"bad login":      {"login": "al ice", "password": "secret123"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var resp map[string]string
+			assert.Equal(t, http.StatusBadRequest, doJSON(t, "POST", srv.URL+"/api/v1/register", "", creds, &resp))
+			assert.NotEmpty(t, resp["error"])
+		})
+	}
+}
+
+// loginTestUser регистрирует пользователя и возвращает его токен
+func loginTestUser(t *testing.T, srv *testGateway, login string) string {
+	t.Helper()
+	creds := map[string]string{"login": login, "password": "secret123"}
+	require.Equal(t, http.StatusOK, doJSON(t, "POST", srv.URL+"/api/v1/register", "", creds, nil))
+
+	var resp struct {
+		Token string `json:"token"`
+	}
+	require.Equal(t, http.StatusOK, doJSON(t, "POST", srv.URL+"/api/v1/login", "", creds, &resp))
+	return resp.Token
+}
+
+func TestCalculateAndListExpressions(t *testing.T) {
+	srv := newTestGateway(t)
+	alice := loginTestUser(t, srv, "alice")
+	bob := loginTestUser(t, srv, "bob")
+
+	var created struct {
+		ExpressionID string `json:"expression_id"`
+		Status       string `json:"status"`
+	}
+	status := doJSON(t, "POST", srv.URL+"/api/v1/calculate", alice, map[string]string{"expression": "2+2*2"}, &created)
+	require.Equal(t, http.StatusAccepted, status)
+	assert.NotEmpty(t, created.ExpressionID)
+	assert.Equal(t, "pending", created.Status)
+
+	var exprs []models.Expression
+	require.Equal(t, http.StatusOK, doJSON(t, "GET", srv.URL+"/api/v1/expressions", alice, nil, &exprs))
+	require.Len(t, exprs, 1)
+	assert.Equal(t, created.ExpressionID, exprs[0].ID)
+	assert.Equal(t, "2+2*2", exprs[0].Expression)
+	assert.Nil(t, exprs[0].Result, "pending expression must have no result")
+
+	exprs = nil
+	require.Equal(t, http.StatusOK, doJSON(t, "GET", srv.URL+"/api/v1/expressions", bob, nil, &exprs))
+	assert.Empty(t, exprs)
+}
+
+func TestCalculateRejects(t *testing.T) {
+	srv := newTestGateway(t)
+	token := loginTestUser(t, srv, "alice")
+
+	assert.Equal(t, http.StatusUnauthorized,
+		doJSON(t, "POST", srv.URL+"/api/v1/calculate", "", map[string]string{"expression": "1+1"}, nil))
+	assert.Equal(t, http.StatusUnauthorized,
+		doJSON(t, "GET", srv.URL+"/api/v1/expressions", "bad-token", nil, nil))
+	assert.Equal(t, http.StatusUnprocessableEntity,
+		doJSON(t, "POST", srv.URL+"/api/v1/calculate", token, map[string]string{"expression": "2+"}, nil))
+	assert.Equal(t, http.StatusBadRequest,
+		doJSON(t, "POST", srv.URL+"/api/v1/calculate", token,
+			map[string]string{"expression": "1+1", "callback_url": "not-a-url"}, nil))
+}
+
+func TestCalculateParseError(t *testing.T) {
+	srv := newTestGateway(t)
+	token := loginTestUser(t, srv, "alice")
+
+	var resp struct {
+		Error    string   `json:"error"`
+		Message  string   `json:"message"`
+		Offset   int      `json:"offset"`
+		Line     int      `json:"line"`
+		Column   int      `json:"column"`
+		Expected []string `json:"expected"`
+		Snippet  string   `json:"snippet"`
+	}
+	require.Equal(t, http.StatusUnprocessableEntity,
+		doJSON(t, "POST", srv.URL+"/api/v1/calculate", token, map[string]string{"expression": "2 * (3 +"}, &resp))
+	assert.Contains(t, resp.Error, "invalid expression")
+	assert.Equal(t, "unexpected end of expression", resp.Message)
+	assert.Equal(t, 8, resp.Offset)
+	assert.Equal(t, 1, resp.Line)
+	assert.Equal(t, 9, resp.Column)
+	assert.Contains(t, resp.Expected, "number")
+	assert.Equal(t, "2 * (3 +\n        ^", resp.Snippet)
+
+	// Неизвестное имя тоже указывает на место в выражении
+	require.Equal(t, http.StatusUnprocessableEntity,
+		doJSON(t, "POST", srv.URL+"/api/v1/calculate", token, map[string]string{"expression": "1 + rate"}, &resp))
+	assert.Equal(t, `unknown variable "rate"`, resp.Message)
+	assert.Equal(t, 4, resp.Offset)
+	assert.Equal(t, "1 + rate\n    ^", resp.Snippet)
+}
+
+func TestGetExpression(t *testing.T) {
+	srv := newTestGateway(t)
+	alice := loginTestUser(t, srv, "alice")
+	bob := loginTestUser(t, srv, "bob")
+
+	var created struct {
+		ExpressionID string `json:"expression_id"`
+	}
+	require.Equal(t, http.StatusAccepted,
+		doJSON(t, "POST", srv.URL+"/api/v1/calculate", alice, map[string]string{"expression": "2+2*3"}, &created))
+	url := srv.URL + "/api/v1/expressions/" + created.ExpressionID
+
+	var detail struct {
+		models.Expression
+		Tasks *struct {
+			models.Task
+			Arg2Task *models.Task `json:"arg2_task"`
+		} `json:"tasks"`
+	}
+	require.Equal(t, http.StatusOK, doJSON(t, "GET", url, alice, nil, &detail))
+	assert.Equal(t, "2+2*3", detail.Expression.Expression)
+	require.NotNil(t, detail.Tasks)
+	assert.Equal(t, "+", detail.Tasks.Operation)
+	assert.Equal(t, "pending", detail.Tasks.Status)
+	require.NotNil(t, detail.Tasks.Arg2Task)
+	assert.Equal(t, "*", detail.Tasks.Arg2Task.Operation)
+	assert.Equal(t, 3.0, detail.Tasks.Arg2Task.Arg2)
+
+	assert.Equal(t, http.StatusNotFound, doJSON(t, "GET", url, bob, nil, nil))
+	assert.Equal(t, http.StatusNotFound, doJSON(t, "GET", srv.URL+"/api/v1/expressions/missing", alice, nil, nil))
+}
+
+func TestWaitForExpression(t *testing.T) {
+	srv := newTestGateway(t)
+	token := loginTestUser(t, srv, "alice")
+	id := createTestExpression(t, srv, token, "2*5")
+
+	// completeLater выполняет следующую задачу, пока запрос ждет; require в
+	// чужой горутине не работает, поэтому только assert
+	completeLater := func(result float64) {
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			task, err := srv.tm.GetNextTask("w1")
+			if assert.NoError(t, err) && assert.NotNil(t, task) {
+				assert.NoError(t, srv.tm.UpdateTaskResult(context.Background(), task.ID, "w1", result))
+			}
+		}()
+	}
+
+	completeLater(10)
+
+	var expr models.Expression
+	start := time.Now()
+	require.Equal(t, http.StatusOK, doJSON(t, "GET", srv.URL+"/api/v1/expressions/"+id+"?wait=10s", token, nil, &expr))
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Equal(t, "completed", expr.Status)
+	require.NotNil(t, expr.Result)
+	assert.Equal(t, 10.0, *expr.Result)
+
+	createTestExpression(t, srv, token, "1+1")
+	var exprs []models.Expression
+	start = time.Now()
+	require.Equal(t, http.StatusOK, doJSON(t, "GET", srv.URL+"/api/v1/expressions?wait=100ms", token, nil, &exprs))
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	assert.Len(t, exprs, 2)
+
+	completeLater(2)
+	exprs = nil
+	require.Equal(t, http.StatusOK, doJSON(t, "GET", srv.URL+"/api/v1/expressions?wait=10s", token, nil, &exprs))
+	for _, e := range exprs {
+		assert.Equal(t, "completed", e.Status)
+	}
+
+	assert.Equal(t, http.StatusBadRequest, doJSON(t, "GET", srv.URL+"/api/v1/expressions?wait=soon", token, nil, nil))
+}
+
+func TestEvaluate(t *testing.T) {
+	srv := newTestGateway(t)
+	token := loginTestUser(t, srv, "alice")
+
+	var expr models.Expression
+	require.Equal(t, http.StatusOK,
+		doJSON(t, "POST", srv.URL+"/api/v1/evaluate", token, map[string]string{"expression": "7"}, &expr))
+	assert.Equal(t, "completed", expr.Status)
+	require.NotNil(t, expr.Result)
+	assert.Equal(t, 7.0, *expr.Result)
+	assert.False(t, expr.CreatedAt.IsZero())
+
+	// Нулевой результат не пропадает из ответа
+	var zero map[string]interface{}
+	require.Equal(t, http.StatusOK,
+		doJSON(t, "POST", srv.URL+"/api/v1/evaluate", token, map[string]string{"expression": "0"}, &zero))
+	assert.Equal(t, 0.0, zero["result"])
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		task, err := srv.tm.GetNextTask("w1")
+		if assert.NoError(t, err) && assert.NotNil(t, task) {
+			assert.NoError(t, srv.tm.UpdateTaskResult(context.Background(), task.ID, "w1", 12))
+		}
+	}()
+	expr = models.Expression{}
+	require.Equal(t, http.StatusOK,
+		doJSON(t, "POST", srv.URL+"/api/v1/evaluate", token, map[string]string{"expression": "3*4", "timeout": "10s"}, &expr))
+	assert.Equal(t, "completed", expr.Status)
+	require.NotNil(t, expr.Result)
+	assert.Equal(t, 12.0, *expr.Result)
+
+	var accepted struct {
+		ExpressionID string `json:"expression_id"`
+		Status       string `json:"status"`
+	}
+	require.Equal(t, http.StatusAccepted,
+		doJSON(t, "POST", srv.URL+"/api/v1/evaluate", token, map[string]string{"expression": "1+1", "timeout": "50ms"}, &accepted))
+	assert.NotEmpty(t, accepted.ExpressionID)
+	assert.Equal(t, "pending", accepted.Status)
+
+	assert.Equal(t, http.StatusUnprocessableEntity,
+		doJSON(t, "POST", srv.URL+"/api/v1/evaluate", token, map[string]string{"expression": "1+"}, nil))
+	assert.Equal(t, http.StatusBadRequest,
+		doJSON(t, "POST", srv.URL+"/api/v1/evaluate", token, map[string]string{"expression": "1+1", "timeout": "x"}, nil))
+}
+
+func TestCalculateDecimal(t *testing.T) {
+	srv := newTestGateway(t)
+	token := loginTestUser(t, srv, "alice")
+
+	var created struct {
+		ExpressionID string `json:"expression_id"`
+	}
+	require.Equal(t, http.StatusAccepted,
+		doJSON(t, "POST", srv.URL+"/api/v1/calculate", token,
+			map[string]any{"expression": "0.1+0.2", "precision": "decimal", "scale": 2, "rounding": "half_up"}, &created))
+
+	task, err := srv.tm.GetNextTask("w1")
+	require.NoError(t, err)
+	require.NotNil(t, task)
+	assert.Equal(t, "0.1", task.ExactArg1)
+	assert.Equal(t, "0.2", task.ExactArg2)
+	assert.Equal(t, 2, task.Scale)
+	exact, err := calculator.CalculateExact(context.Background(), task)
+	require.NoError(t, err)
+	require.NoError(t, srv.tm.UpdateTaskExactResult(context.Background(), task, "w1", exact))
+
+	var expr models.Expression
+	require.Equal(t, http.StatusOK,
+		doJSON(t, "GET", srv.URL+"/api/v1/expressions/"+created.ExpressionID, token, nil, &expr))
+	assert.Equal(t, "completed", expr.Status)
+	assert.Equal(t, "decimal", expr.Precision)
+	assert.Equal(t, "0.3", expr.ExactResult)
+	require.NotNil(t, expr.Result)
+	assert.Equal(t, 0.3, *expr.Result)
+	require.NotNil(t, expr.Scale)
+	assert.Equal(t, 2, *expr.Scale)
+	assert.Equal(t, "half_up", expr.Rounding)
+
+	expr = models.Expression{}
+	require.Equal(t, http.StatusOK,
+		doJSON(t, "POST", srv.URL+"/api/v1/evaluate", token, map[string]any{"expression": "-1.50", "precision": "decimal"}, &expr))
+	assert.Equal(t, "-1.5", expr.ExactResult)
+	require.NotNil(t, expr.Scale)
+	assert.Equal(t, 10, *expr.Scale, "default scale")
+
+	for _, body := range []map[string]any{
+		{"expression": "1/3", "precision": "quad"},
+		{"expression": "1/3", "precision": "decimal", "rounding": "nearest"},
+		{"expression": "1/3", "precision": "decimal", "scale": 1000},
+	} {
+		assert.Equal(t, http.StatusBadRequest, doJSON(t, "POST", srv.URL+"/api/v1/calculate", token, body, nil), body)
+	}
+}
+
+func TestCalculateRational(t *testing.T) {
+	srv := newTestGateway(t)
+	token := loginTestUser(t, srv, "alice")
+
+	var created struct {
+		ExpressionID string `json:"expression_id"`
+	}
+	require.Equal(t, http.StatusAccepted,
+		doJSON(t, "POST", srv.URL+"/api/v1/calculate", token,
+			map[string]any{"expression": "1/3*3", "precision": "rational", "scale": 4}, &created))
+
+	for _, want := range []struct{ arg1, arg2 string }{{"1", "3"}, {"1/3", "3"}} {
+		task, err := srv.tm.GetNextTask("w1")
+		require.NoError(t, err)
+		require.NotNil(t, task)
+		assert.Equal(t, want.arg1, task.ExactArg1)
+		assert.Equal(t, want.arg2, task.ExactArg2)
+		exact, err := calculator.CalculateExact(context.Background(), task)
+		require.NoError(t, err)
+		require.NoError(t, srv.tm.UpdateTaskExactResult(context.Background(), task, "w1", exact))
+	}
+
+	var expr models.Expression
+	require.Equal(t, http.StatusOK,
+		doJSON(t, "GET", srv.URL+"/api/v1/expressions/"+created.ExpressionID, token, nil, &expr))
+	assert.Equal(t, "completed", expr.Status)
+	assert.Equal(t, "rational", expr.Precision)
+	assert.Equal(t, "1", expr.ExactResult)
+	assert.Equal(t, "1", expr.DecimalResult)
+	require.NotNil(t, expr.Result)
+	assert.Equal(t, 1.0, *expr.Result)
+
+	expr = models.Expression{}
+	require.Equal(t, http.StatusOK,
+		doJSON(t, "POST", srv.URL+"/api/v1/evaluate", token,
+			map[string]any{"expression": "-0.25", "precision": "rational"}, &expr))
+	assert.Equal(t, "-1/4", expr.ExactResult)
+	assert.Equal(t, "-0.25", expr.DecimalResult)
+}
+
+func TestOptimizedExpressions(t *testing.T) {
+	srv := newOptimizingTestGateway(t, calculator.Optimizations{Fold: true, Identities: true, ShortCircuit: true})
+	token := loginTestUser(t, srv, "alice")
+
+	// Выражение из одних чисел вычисляется без очереди
+	var expr models.Expression
+	require.Equal(t, http.StatusOK,
+		doJSON(t, "POST", srv.URL+"/api/v1/evaluate", token, map[string]string{"expression": "0*(2+3) + 2*3"}, &expr))
+	assert.Equal(t, "completed", expr.Status)
+	require.NotNil(t, expr.Result)
+	assert.Equal(t, 6.0, *expr.Result)
+	assert.Equal(t, 4, expr.EliminatedTasks)
+
+	// calculate отвечает в том же виде, но для свернутого при отправке выражения уже с результатом
+	var created calculateResponse
+	require.Equal(t, http.StatusOK,
+		doJSON(t, "POST", srv.URL+"/api/v1/calculate", token, map[string]string{"expression": "2*3"}, &created))
+	assert.NotEmpty(t, created.ExpressionID)
+	assert.Equal(t, "completed", created.Status)
+	require.NotNil(t, created.Result)
+	assert.Equal(t, 6.0, *created.Result)
+
+	created = calculateResponse{}
+	require.Equal(t, http.StatusAccepted,
+		doJSON(t, "POST", srv.URL+"/api/v1/calculate", token, map[string]string{"expression": "1/0 + 2*3"}, &created))
+	assert.Equal(t, "pending", created.Status)
+	assert.Nil(t, created.Result)
+	var detail expressionDetail
+	require.Equal(t, http.StatusOK,
+		doJSON(t, "GET", srv.URL+"/api/v1/expressions/"+created.ExpressionID, token, nil, &detail))
+	assert.Equal(t, 1, detail.EliminatedTasks)
+	require.NotNil(t, detail.Tasks)
+	assert.Equal(t, "+", detail.Tasks.Operation)
+	assert.Equal(t, 6.0, detail.Tasks.Arg2)
+}
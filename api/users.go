@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/m1tka051209/calculator-service/db"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	minLoginLength    = 3
+	maxLoginLength    = 32
+	minPasswordLength = 8
+	// maxPasswordBytes ограничение bcrypt: более длинные пароли он отвергает
+	maxPasswordBytes = 72
+)
+
+// ErrInvalidCredentials возвращается при неверном логине или пароле.
+// Какая из двух частей неверна, не сообщается.
+var ErrInvalidCredentials = errors.New("invalid login or password")
+
+// PolicyError сообщает, почему логин или пароль не подходит для регистрации
+type PolicyError struct {
+	Reason string
+}
+
+func (e *PolicyError) Error() string {
+	return e.Reason
+}
+
+// validateCredentials проверяет логин и пароль нового пользователя
+func validateCredentials(login, password string) error {
+	n := utf8.RuneCountInString(login)
+	if n < minLoginLength || n > maxLoginLength {
+		return &PolicyError{fmt.Sprintf("login must be %d to %d characters long", minLoginLength, maxLoginLength)}
+	}
+	for _, r := range login {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '-' && r != '.' {
+			return &PolicyError{"login may contain only letters, digits, '_', '-' and '.'"}
+		}
+	}
+
+	if utf8.RuneCountInString(password) < minPasswordLength {
+		return &PolicyError{fmt.Sprintf("password must be at least %d characters long", minPasswordLength)}
+	}
+	if len(password) > maxPasswordBytes {
+		return &PolicyError{fmt.Sprintf("password must be at most %d bytes long", maxPasswordBytes)}
+	}
+	var hasLetter, hasDigit bool
+	for _, r := range password {
+		hasLetter = hasLetter || unicode.IsLetter(r)
+		hasDigit = hasDigit || unicode.IsDigit(r)
+	}
+	if !hasLetter || !hasDigit {
+		return &PolicyError{"password must contain both letters and digits"}
+	}
+	return nil
+}
+
+// registerUser проверяет политику паролей и сохраняет пользователя с bcrypt-хешем пароля
+func registerUser(ctx context.Context, repo db.Repository, login, password string) error {
+	if err := validateCredentials(login, password); err != nil {
+		return err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	return repo.CreateUser(ctx, login, string(hash))
+}
+
+// dummyHash сравнивается с паролем, когда логина нет, чтобы время ответа
+// не выдавало существование пользователя
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password 0"), bcrypt.DefaultCost)
+
+// authenticate проверяет логин и пароль и возвращает ID пользователя
+func authenticate(ctx context.Context, repo db.Repository, login, password string) (string, error) {
+	user, err := repo.GetUserByLogin(ctx, login)
+	if errors.Is(err, db.ErrUserNotFound) {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return "", ErrInvalidCredentials
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return "", ErrInvalidCredentials
+	}
+	return user.ID, nil
+}
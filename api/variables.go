@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/m1tka051209/calculator-service/calculator"
+	"github.com/m1tka051209/calculator-service/db"
+	"github.com/m1tka051209/calculator-service/models"
+)
+
+// registerVariableRoutes регистрирует эндпоинты переменных пользователя. Переменные
+// подставляются в выражения при отправке, так что их изменение не влияет на уже
+// отправленные выражения.
+func registerVariableRoutes(mux *http.ServeMux, repo db.Repository) {
+	// Переменные пользователя и встроенные константы
+	mux.HandleFunc("GET /api/v1/variables", requireUser(func(w http.ResponseWriter, r *http.Request, userID string) {
+		vars, err := repo.GetVariables(r.Context(), userID)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+			return
+		}
+		if vars == nil {
+			vars = []models.Variable{}
+		}
+		respondJSON(w, http.StatusOK, map[string]interface{}{"variables": vars, "constants": calculator.Constants})
+	}))
+
+	mux.HandleFunc("GET /api/v1/variables/{name}", requireUser(func(w http.ResponseWriter, r *http.Request, userID string) {
+		v, err := repo.GetVariable(r.Context(), userID, r.PathValue("name"))
+		if errors.Is(err, db.ErrVariableNotFound) {
+			respondJSON(w, http.StatusNotFound, map[string]string{"error": "variable not found"})
+			return
+		}
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+			return
+		}
+		respondJSON(w, http.StatusOK, v)
+	}))
+
+	// Создание или изменение переменной
+	mux.HandleFunc("PUT /api/v1/variables/{name}", requireUser(func(w http.ResponseWriter, r *http.Request, userID string) {
+		var req struct {
+			Value *float64 `json:"value"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Value == nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request: expected {\"value\": number}"})
+			return
+		}
+		v := &models.Variable{UserID: userID, Name: r.PathValue("name"), Value: *req.Value}
+		if err := calculator.ValidateVariable(v.Name, v.Value); err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if err := repo.SetVariable(r.Context(), v); err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+			return
+		}
+		respondJSON(w, http.StatusOK, v)
+	}))
+
+	mux.HandleFunc("DELETE /api/v1/variables/{name}", requireUser(func(w http.ResponseWriter, r *http.Request, userID string) {
+		err := repo.DeleteVariable(r.Context(), userID, r.PathValue("name"))
+		if errors.Is(err, db.ErrVariableNotFound) {
+			respondJSON(w, http.StatusNotFound, map[string]string{"error": "variable not found"})
+			return
+		}
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+	}))
+}
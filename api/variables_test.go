@@ -0,0 +1,72 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/m1tka051209/calculator-service/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVariables(t *testing.T) {
+	srv := newTestGateway(t)
+	alice := loginTestUser(t, srv, "alice")
+	bob := loginTestUser(t, srv, "bob")
+
+	var v models.Variable
+	require.Equal(t, http.StatusOK,
+		doJSON(t, "PUT", srv.URL+"/api/v1/variables/rate", alice, map[string]any{"value": 0.13}, &v))
+	assert.Equal(t, "rate", v.Name)
+	assert.Equal(t, 0.13, v.Value)
+	require.Equal(t, http.StatusOK,
+		doJSON(t, "PUT", srv.URL+"/api/v1/variables/price", alice, map[string]any{"value": 100}, nil))
+
+	var list struct {
+		Variables []models.Variable  `json:"variables"`
+		Constants map[string]float64 `json:"constants"`
+	}
+	require.Equal(t, http.StatusOK, doJSON(t, "GET", srv.URL+"/api/v1/variables", alice, nil, &list))
+	assert.Len(t, list.Variables, 2)
+	assert.Contains(t, list.Constants, "pi")
+
+	var created struct {
+		ExpressionID string `json:"expression_id"`
+	}
+	require.Equal(t, http.StatusAccepted,
+		doJSON(t, "POST", srv.URL+"/api/v1/calculate", alice, map[string]string{"expression": "price * (1 + rate)"}, &created))
+
+	// Изменение переменной не влияет на уже отправленное выражение
+	require.Equal(t, http.StatusOK,
+		doJSON(t, "PUT", srv.URL+"/api/v1/variables/rate", alice, map[string]any{"value": 0.2}, nil))
+	var expr models.Expression
+	require.Equal(t, http.StatusOK,
+		doJSON(t, "GET", srv.URL+"/api/v1/expressions/"+created.ExpressionID, alice, nil, &expr))
+	assert.Equal(t, map[string]float64{"price": 100, "rate": 0.13}, expr.Variables)
+
+	expr = models.Expression{}
+	require.Equal(t, http.StatusOK,
+		doJSON(t, "POST", srv.URL+"/api/v1/evaluate", alice, map[string]string{"expression": "-rate"}, &expr))
+	require.NotNil(t, expr.Result)
+	assert.Equal(t, -0.2, *expr.Result)
+
+	// Переменные других пользователей не видны
+	assert.Equal(t, http.StatusUnprocessableEntity,
+		doJSON(t, "POST", srv.URL+"/api/v1/calculate", bob, map[string]string{"expression": "rate"}, nil))
+	assert.Equal(t, http.StatusNotFound, doJSON(t, "GET", srv.URL+"/api/v1/variables/rate", bob, nil, nil))
+
+	require.Equal(t, http.StatusOK, doJSON(t, "GET", srv.URL+"/api/v1/variables/rate", alice, nil, &v))
+	assert.Equal(t, 0.2, v.Value)
+	require.Equal(t, http.StatusOK, doJSON(t, "DELETE", srv.URL+"/api/v1/variables/rate", alice, nil, nil))
+	assert.Equal(t, http.StatusNotFound, doJSON(t, "DELETE", srv.URL+"/api/v1/variables/rate", alice, nil, nil))
+
+	for name, body := range map[string]any{
+		"pi":   map[string]any{"value": 1},
+		"sqrt": map[string]any{"value": 1},
+		"1x":   map[string]any{"value": 1},
+		"x":    map[string]any{"val": 1},
+	} {
+		assert.Equal(t, http.StatusBadRequest,
+			doJSON(t, "PUT", srv.URL+"/api/v1/variables/"+name, alice, body, nil), name)
+	}
+}
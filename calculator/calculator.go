@@ -1,45 +1,124 @@
 package calculator
 
 import (
-	"github.com/m1tka051209/calculator-service/models"
+	"context"
+	"fmt"
+	"math"
 	"time"
-	"unicode"
+
+	"github.com/m1tka051209/calculator-service/models"
 )
 
-func Calculate(task *models.Task) float64 {
-	time.Sleep(time.Duration(task.OperationTime) * time.Millisecond)
-	
+// OperationTimes время выполнения каждой операции; операции без записи выполняются без задержки
+type OperationTimes map[string]time.Duration
+
+// Apply проставляет задачам время выполнения их операций
+func (t OperationTimes) Apply(tasks []models.Task) {
+	for i := range tasks {
+		tasks[i].OperationTime = int(t[tasks[i].Operation].Milliseconds())
+	}
+}
+
+// Calculate выполняет операцию задачи, предварительно выждав ее OperationTime.
+// Если ctx отменен во время ожидания, возвращается ошибка контекста.
+// Деление на ноль, неизвестная операция, аргумент вне области определения функции
+// и результат, не представимый во float64, возвращаются как *CalculationError.
+func Calculate(ctx context.Context, task *models.Task) (float64, error) {
+	if err := wait(ctx, time.Duration(task.OperationTime)*time.Millisecond); err != nil {
+		return 0, err
+	}
+
+	var result float64
+	if fn, ok := LookupFunction(task.Operation); ok {
+		if !fn.acceptsArgs(len(task.Args)) {
+			return 0, calculationError(task, fmt.Errorf("%w: %d arguments, %s", ErrArgumentCount, len(task.Args), fn.arity()))
+		}
+		var err error
+		if result, err = fn.Call(task.Args); err != nil {
+			return 0, calculationError(task, err)
+		}
+		return checkResult(task, result)
+	}
+
 	switch task.Operation {
 	case "+":
-		return task.Arg1 + task.Arg2
+		result = task.Arg1 + task.Arg2
 	case "-":
-		return task.Arg1 - task.Arg2
+		result = task.Arg1 - task.Arg2
 	case "*":
-		return task.Arg1 * task.Arg2
+		result = task.Arg1 * task.Arg2
 	case "/":
 		if task.Arg2 == 0 {
-			return 0
+			return 0, calculationError(task, ErrDivisionByZero)
+		}
+		result = task.Arg1 / task.Arg2
+	case "//":
+		if task.Arg2 == 0 {
+			return 0, calculationError(task, ErrDivisionByZero)
 		}
-		return task.Arg1 / task.Arg2
+		result = math.Floor(task.Arg1 / task.Arg2)
+	case "%":
+		if task.Arg2 == 0 {
+			return 0, calculationError(task, ErrModuloByZero)
+		}
+		// Остаток со знаком делимого, как у % в Go
+		result = math.Mod(task.Arg1, task.Arg2)
+	case "^":
+		if task.Arg1 == 0 && task.Arg2 < 0 {
+			return 0, calculationError(task, ErrDivisionByZero)
+		}
+		result = math.Pow(task.Arg1, task.Arg2)
 	default:
-		return 0
+		return 0, calculationError(task, ErrUnknownOperator)
+	}
+	return checkResult(task, result)
+}
+
+// checkResult отклоняет результаты, не представимые во float64
+func checkResult(task *models.Task, result float64) (float64, error) {
+	switch {
+	case math.IsNaN(result):
+		return 0, calculationError(task, ErrNaN)
+	case math.IsInf(result, 0):
+		return 0, calculationError(task, ErrOverflow)
+	}
+	return result, nil
+}
+
+// wait ждет d или отмены ctx
+func wait(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func calculationError(task *models.Task, err error) error {
+	if _, ok := LookupFunction(task.Operation); ok {
+		return &CalculationError{Operation: task.Operation, Args: task.Args, Err: err}
 	}
+	return &CalculationError{Operation: task.Operation, Arg1: task.Arg1, Arg2: task.Arg2, Err: err}
 }
 
 func ValidateOperation(op string) bool {
 	switch op {
-	case "+", "-", "*", "/":
+	case "+", "-", "*", "/", "//", "%", "^":
 		return true
 	default:
-		return false
+		_, ok := LookupFunction(op)
+		return ok
 	}
 }
 
 func ValidateExpression(expr string) bool {
-    for _, c := range expr {
-        if !unicode.IsDigit(c) && c != '+' && c != '-' && c != '*' && c != '/' && c != ' ' {
-            return false
-        }
-    }
-    return true
+	_, err := Parse(expr)
+	return err == nil
 }
\ No newline at end of file
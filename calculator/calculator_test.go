@@ -1,7 +1,11 @@
 package calculator
 
 import (
+	"context"
+	"errors"
+	"math"
 	"testing"
+	"time"
 	"github.com/m1tka051209/calculator-service/models"
 )
 
@@ -10,16 +14,59 @@ func TestCalculate(t *testing.T) {
 		name     string
 		task     models.Task
 		expected float64
+		err      error
 	}{
-		{"addition", models.Task{Arg1: 2, Arg2: 3, Operation: "+"}, 5},
-		{"division by zero", models.Task{Arg1: 5, Arg2: 0, Operation: "/"}, 0},
+		{"addition", models.Task{Arg1: 2, Arg2: 3, Operation: "+"}, 5, nil},
+		{"zero result", models.Task{Arg1: 0, Arg2: 5, Operation: "/"}, 0, nil},
+		{"division by zero", models.Task{Arg1: 5, Arg2: 0, Operation: "/"}, 0, ErrDivisionByZero},
+		{"power", models.Task{Arg1: 2, Arg2: 10, Operation: "^"}, 1024, nil},
+		{"zero to negative power", models.Task{Arg1: 0, Arg2: -1, Operation: "^"}, 0, ErrDivisionByZero},
+		{"modulo", models.Task{Arg1: -7, Arg2: 3, Operation: "%"}, -1, nil},
+		{"modulo by zero", models.Task{Arg1: 7, Arg2: 0, Operation: "%"}, 0, ErrModuloByZero},
+		{"integer division", models.Task{Arg1: -7, Arg2: 2, Operation: "//"}, -4, nil},
+		{"integer division by zero", models.Task{Arg1: 7, Arg2: 0, Operation: "//"}, 0, ErrDivisionByZero},
+		{"function", models.Task{Args: []float64{9}, Operation: "sqrt"}, 3, nil},
+		{"variadic function", models.Task{Args: []float64{4, -1, 7}, Operation: "min"}, -1, nil},
+		{"square root of negative", models.Task{Args: []float64{-1}, Operation: "sqrt"}, 0, ErrDomain},
+		{"logarithm of zero", models.Task{Args: []float64{0}, Operation: "ln"}, 0, ErrDomain},
+		{"function argument count", models.Task{Args: []float64{1, 2}, Operation: "abs"}, 0, ErrArgumentCount},
+		{"function overflow", models.Task{Args: []float64{1000}, Operation: "exp"}, 0, ErrOverflow},
+		{"unknown operator", models.Task{Arg1: 5, Arg2: 1, Operation: "?"}, 0, ErrUnknownOperator},
+		{"overflow", models.Task{Arg1: math.MaxFloat64, Arg2: 10, Operation: "*"}, 0, ErrOverflow},
+		{"nan", models.Task{Arg1: math.Inf(1), Arg2: math.Inf(1), Operation: "-"}, 0, ErrNaN},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := Calculate(&tt.task); got != tt.expected {
+			got, err := Calculate(context.Background(), &tt.task)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Calculate() error = %v, want %v", err, tt.err)
+			}
+			if got != tt.expected {
 				t.Errorf("Calculate() = %v, want %v", got, tt.expected)
 			}
 		})
 	}
+}
+
+func TestCalculateCanceled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := Calculate(ctx, &models.Task{Arg1: 1, Arg2: 2, Operation: "+", OperationTime: 10000})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Calculate() error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Calculate() returned after %s, want prompt cancellation", elapsed)
+	}
+}
+
+func TestOperationTimesApply(t *testing.T) {
+	tasks := []models.Task{{Operation: "+"}, {Operation: "/"}}
+	OperationTimes{"+": 150 * time.Millisecond}.Apply(tasks)
+	if tasks[0].OperationTime != 150 || tasks[1].OperationTime != 0 {
+		t.Errorf("Apply() = %d, %d, want 150, 0", tasks[0].OperationTime, tasks[1].OperationTime)
+	}
 }
\ No newline at end of file
@@ -0,0 +1,115 @@
+package calculator
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/m1tka051209/calculator-service/models"
+)
+
+// ErrInvalidFunction возвращается для недопустимого определения пользовательской функции
+var ErrInvalidFunction = errors.New("invalid function")
+
+// ParseDefinition разбирает определение пользовательской функции вида
+// vat(x) = x * 0.2 и проверяет его как ValidateFunction
+func ParseDefinition(def string) (*models.UserFunction, error) {
+	head, body, ok := strings.Cut(def, "=")
+	if !ok {
+		return nil, fmt.Errorf("%w: expected a definition such as f(x) = x * 2", ErrInvalidFunction)
+	}
+	tokens, err := tokenize(head)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFunction, err)
+	}
+
+	// name "(" [ param { "," param } ] ")"
+	fn := &models.UserFunction{Body: strings.TrimSpace(body), Params: []string{}}
+	bad := func(tok token) error {
+		return fmt.Errorf("%w: unexpected %s at position %d, expected a definition such as f(x) = x * 2",
+			ErrInvalidFunction, tok, tok.pos)
+	}
+	if tokens[0].kind != tokenIdent {
+		return nil, bad(tokens[0])
+	}
+	fn.Name = tokens[0].text
+	if tokens[1].kind != tokenLParen {
+		return nil, bad(tokens[1])
+	}
+	i := 2
+	if tokens[i].kind != tokenRParen {
+		for {
+			if tokens[i].kind != tokenIdent {
+				return nil, bad(tokens[i])
+			}
+			fn.Params = append(fn.Params, tokens[i].text)
+			i++
+			if tokens[i].kind == tokenRParen {
+				break
+			}
+			if tokens[i].kind != tokenComma {
+				return nil, bad(tokens[i])
+			}
+			i++
+		}
+	}
+	if tokens[i+1].kind != tokenEOF {
+		return nil, bad(tokens[i+1])
+	}
+
+	if err := ValidateFunction(fn); err != nil {
+		return nil, err
+	}
+	return fn, nil
+}
+
+// ValidateFunction проверяет пользовательскую функцию: имя и параметры не заняты
+// встроенными функциями и константами, параметры не повторяются, тело разбирается
+// и не вызывает саму функцию. Остальные имена и вызовы в теле проверяются при
+// отправке выражения, так как переменные и другие функции могут появиться позже.
+func ValidateFunction(fn *models.UserFunction) error {
+	if err := checkName(fn.Name); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidFunction, err)
+	}
+	for i, param := range fn.Params {
+		if err := checkName(param); err != nil {
+			return fmt.Errorf("%w: parameter: %v", ErrInvalidFunction, err)
+		}
+		if slices.Contains(fn.Params[:i], param) {
+			return fmt.Errorf("%w: duplicate parameter %q", ErrInvalidFunction, param)
+		}
+	}
+
+	body, err := Parse(fn.Body)
+	if err != nil {
+		return fmt.Errorf("%w: body: %v", ErrInvalidFunction, err)
+	}
+	if call := findCall(body, fn.Name); call != nil {
+		return fmt.Errorf("%w: function %q calls itself at position %d of its body", ErrInvalidFunction, fn.Name, call.Pos)
+	}
+	return nil
+}
+
+// findCall возвращает первый вызов функции name в дереве или nil
+func findCall(n Node, name string) *CallNode {
+	switch n := n.(type) {
+	case *UnaryNode:
+		return findCall(n.Operand, name)
+	case *BinaryNode:
+		if call := findCall(n.Left, name); call != nil {
+			return call
+		}
+		return findCall(n.Right, name)
+	case *CallNode:
+		if n.Name == name {
+			return n
+		}
+		for _, arg := range n.Args {
+			if call := findCall(arg, name); call != nil {
+				return call
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package calculator
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/m1tka051209/calculator-service/models"
+)
+
+func TestParseDefinition(t *testing.T) {
+	fn, err := ParseDefinition(" vat ( x ) = x * 0.2 ")
+	if err != nil {
+		t.Fatalf("ParseDefinition() error = %v", err)
+	}
+	if fn.Name != "vat" || !slices.Equal(fn.Params, []string{"x"}) || fn.Body != "x * 0.2" {
+		t.Errorf("ParseDefinition() = %+v", fn)
+	}
+	if got := fn.Definition(); got != "vat(x) = x * 0.2" {
+		t.Errorf("Definition() = %q", got)
+	}
+
+	fn, err = ParseDefinition("answer() = 42")
+	if err != nil || fn.Name != "answer" || len(fn.Params) != 0 {
+		t.Errorf("ParseDefinition() = %+v, %v, want answer without parameters", fn, err)
+	}
+
+	for _, def := range []string{
+		"", "f(x)", "f = 1", "(x) = x", "f(x, ) = x", "f(x y) = x", "f(x)) = x", "f(1) = 1",
+		"f(x) = x +", "f(x) = f(x - 1)", "f(x, x) = x", "sqrt(x) = x", "pi() = 3", "f(e) = e", "f(x) = x = 1",
+	} {
+		t.Run(def, func(t *testing.T) {
+			if _, err := ParseDefinition(def); !errors.Is(err, ErrInvalidFunction) {
+				t.Errorf("ParseDefinition(%q) error = %v, want ErrInvalidFunction", def, err)
+			}
+		})
+	}
+}
+
+// userFunctions разбирает определения функций для Options.Functions
+func userFunctions(t *testing.T, defs ...string) map[string]models.UserFunction {
+	t.Helper()
+	fns := map[string]models.UserFunction{}
+	for _, def := range defs {
+		fn, err := ParseDefinition(def)
+		if err != nil {
+			t.Fatalf("ParseDefinition(%q) error = %v", def, err)
+		}
+		fns[fn.Name] = *fn
+	}
+	return fns
+}
+
+func TestDecomposeUserFunctions(t *testing.T) {
+	opts := Options{
+		Variables: map[string]float64{"rate": 0.5, "x": 100},
+		Functions: userFunctions(t,
+			"vat(x) = x * 0.2",
+			"gross(x) = x + vat(x)",
+			"scaled(y) = y * rate",
+			"sq(x) = x * x",
+			"hyp(a, b) = sqrt(sq(a) + sq(b))",
+		),
+	}
+
+	tests := []struct {
+		expr  string
+		want  float64
+		tasks int
+	}{
+		{"vat(100)", 20, 1},
+		{"gross(50)", 60, 2},
+		{"scaled(4)", 2, 1},
+		{"vat(x) + x", 120, 2},
+		// Аргумент-подвыражение вычисляется одной задачей, хотя в теле он дважды
+		{"sq(1 + 2)", 9, 2},
+		{"hyp(3, 4)", 5, 4},
+		{"sq(2) + sq(2)", 8, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			plan, err := DecomposeWith(tt.expr, opts)
+			if err != nil {
+				t.Fatalf("DecomposeWith() error = %v", err)
+			}
+			if len(plan.Tasks) != tt.tasks {
+				t.Errorf("DecomposeWith() produced %d tasks, want %d", len(plan.Tasks), tt.tasks)
+			}
+			if got := evalPlan(t, plan); got != tt.want {
+				t.Errorf("result = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	plan, err := DecomposeWith("gross(scaled(2))", opts)
+	if err != nil {
+		t.Fatalf("DecomposeWith() error = %v", err)
+	}
+	if len(plan.Functions) != 3 || plan.Functions["vat"] != "vat(x) = x * 0.2" {
+		t.Errorf("Functions = %v, want gross, scaled and vat", plan.Functions)
+	}
+	if len(plan.Variables) != 1 || plan.Variables["rate"] != 0.5 {
+		t.Errorf("Variables = %v, want only rate", plan.Variables)
+	}
+}
+
+func TestDecomposeUserFunctionErrors(t *testing.T) {
+	fns := userFunctions(t,
+		"vat(x) = x * 0.2",
+		"ping(x) = pong(x)",
+		"pong(x) = ping(x)",
+		"free(x) = x * missing",
+		"bad(x) = nothere(x)",
+	)
+	// Цепочка f1 -> f2 -> ... глубже MaxCallDepth
+	for i := 1; i <= MaxCallDepth+1; i++ {
+		fns["f"+strings.Repeat("1", i)] = models.UserFunction{
+			Name: "f" + strings.Repeat("1", i), Params: []string{"x"}, Body: "f" + strings.Repeat("1", i+1) + "(x)",
+		}
+	}
+	fns["f"+strings.Repeat("1", MaxCallDepth+2)] = models.UserFunction{
+		Name: "f" + strings.Repeat("1", MaxCallDepth+2), Params: []string{"x"}, Body: "x",
+	}
+	// Каждая следующая функция вызывает предыдущую дважды с разными аргументами
+	fns["d0"] = models.UserFunction{Name: "d0", Params: []string{"x"}, Body: "x + 1"}
+	chain := "d0"
+	for i := 1; i <= 15; i++ {
+		name := "d" + strings.Repeat("d", i)
+		fns[name] = models.UserFunction{Name: name, Params: []string{"x"}, Body: chain + "(x) + " + chain + "(x + 1)"}
+		chain = name
+	}
+
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"vat(1, 2)", "called with 2 arguments"},
+		{"nope(1)", `unknown function "nope"`},
+		{"ping(1)", "recursive call"},
+		{"free(1)", `unknown variable "missing" (position 4 in function "free") at position 0`},
+		{"bad(1)", `unknown function "nothere" (position 0 in function "bad") at position 0`},
+		{"f1(1)", "nested deeper"},
+		{chain + "(1)", "too large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := DecomposeWith(tt.expr, Options{Functions: fns})
+			if !errors.Is(err, ErrInvalidExpression) || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("DecomposeWith(%q) error = %v, want ErrInvalidExpression with %q", tt.expr, err, tt.want)
+			}
+		})
+	}
+	// Ошибка в теле функции указывает на ее вызов в выражении
+	_, err := DecomposeWith("1 + free(1)", Options{Functions: fns})
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Offset != 4 {
+		t.Errorf("DecomposeWith(1 + free(1)) error = %#v, want *ParseError at offset 4", err)
+	}
+}
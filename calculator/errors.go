@@ -0,0 +1,117 @@
+package calculator
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Ошибки вычисления задачи. Calculate оборачивает их в *CalculationError,
+// проверять вид ошибки нужно через errors.Is.
+var (
+	ErrDivisionByZero  = errors.New("division by zero")
+	ErrModuloByZero    = errors.New("modulo by zero")
+	ErrUnknownOperator = errors.New("unknown operator")
+	ErrOverflow        = errors.New("result overflows float64")
+	ErrNaN             = errors.New("result is not a number")
+	ErrDomain          = errors.New("argument out of domain")
+	ErrArgumentCount   = errors.New("wrong number of arguments")
+)
+
+// CalculationError ошибка вычисления конкретной операции
+type CalculationError struct {
+	Operation string
+	Arg1      float64
+	Arg2      float64
+	// Args аргументы, если операция - вызов функции
+	Args []float64
+	Err  error
+}
+
+func (e *CalculationError) Error() string {
+	if e.Args != nil {
+		args := make([]string, len(e.Args))
+		for i, arg := range e.Args {
+			args[i] = fmt.Sprint(arg)
+		}
+		return fmt.Sprintf("%s(%s): %v", e.Operation, strings.Join(args, ", "), e.Err)
+	}
+	return fmt.Sprintf("%v %s %v: %v", e.Arg1, e.Operation, e.Arg2, e.Err)
+}
+
+func (e *CalculationError) Unwrap() error {
+	return e.Err
+}
+
+// ParseError ошибка в тексте выражения: синтаксическая, а также неизвестное имя
+// или неверный вызов функции, найденные при разложении. Оборачивает
+// ErrInvalidExpression.
+type ParseError struct {
+	// Message описание ошибки без позиции
+	Message string `json:"message"`
+	// Offset позиция ошибки в байтах от начала выражения
+	Offset int `json:"offset"`
+	// Line и Column строка и столбец в символах, считая с 1
+	Line   int `json:"line"`
+	Column int `json:"column"`
+	// Expected что могло стоять на месте ошибки, если это известно
+	Expected []string `json:"expected,omitempty"`
+	// Input разбиравшееся выражение
+	Input string `json:"-"`
+}
+
+func newParseError(input string, offset int, expected []string, format string, args ...interface{}) *ParseError {
+	before := input[:offset]
+	lineStart := strings.LastIndexByte(before, '\n') + 1
+	return &ParseError{
+		Message:  fmt.Sprintf(format, args...),
+		Offset:   offset,
+		Line:     strings.Count(before, "\n") + 1,
+		Column:   utf8.RuneCountInString(before[lineStart:]) + 1,
+		Expected: expected,
+		Input:    input,
+	}
+}
+
+func (e *ParseError) Error() string {
+	msg := fmt.Sprintf("%v: %s at position %d", ErrInvalidExpression, e.Message, e.Offset)
+	if len(e.Expected) > 0 {
+		last := len(e.Expected) - 1
+		alternatives := e.Expected[last]
+		if last > 0 {
+			alternatives = strings.Join(e.Expected[:last], ", ") + " or " + alternatives
+		}
+		msg += ", expected " + alternatives
+	}
+	return msg
+}
+
+func (e *ParseError) Unwrap() error {
+	return ErrInvalidExpression
+}
+
+// Snippet строка выражения с ошибкой и под ней знак ^ на месте ошибки:
+//
+//	2 * (3 +
+//	        ^
+func (e *ParseError) Snippet() string {
+	lineStart := strings.LastIndexByte(e.Input[:e.Offset], '\n') + 1
+	line := e.Input[lineStart:]
+	if end := strings.IndexByte(line, '\n'); end >= 0 {
+		line = line[:end]
+	}
+	line = strings.TrimSuffix(line, "\r")
+
+	// Табуляции повторяются, чтобы знак оказался под нужным символом
+	var caret strings.Builder
+	for _, c := range e.Input[lineStart:e.Offset] {
+		if c == '\t' {
+			caret.WriteByte('\t')
+		} else {
+			caret.WriteByte(' ')
+		}
+	}
+	caret.WriteByte('^')
+	return line + "\n" + caret.String()
+}
@@ -0,0 +1,417 @@
+package calculator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+
+	"github.com/m1tka051209/calculator-service/models"
+)
+
+// Режимы точности выражения (models.Expression.Precision)
+const (
+	// PrecisionFloat вычисления во float64; пустой режим означает то же самое
+	PrecisionFloat = "float"
+	// PrecisionDecimal точные десятичные вычисления: сложение, вычитание и умножение
+	// не теряют точности, остальное округляется до Scale знаков по Rounding
+	PrecisionDecimal = "decimal"
+	// PrecisionRational вычисления в рациональных числах: точны все операции, кроме
+	// иррациональных (корни, трансцендентные функции, дробные степени), которые
+	// округляются до Scale знаков. Результат - несократимая дробь.
+	PrecisionRational = "rational"
+)
+
+// Режимы округления десятичных результатов
+const (
+	RoundHalfEven = "half_even"
+	RoundHalfUp   = "half_up"
+	RoundHalfDown = "half_down"
+	RoundUp       = "up"
+	RoundDown     = "down"
+	RoundCeiling  = "ceiling"
+	RoundFloor    = "floor"
+)
+
+// MaxScale наибольшее допустимое число знаков после запятой
+const MaxScale = 100
+
+// maxExactExponent наибольший по модулю целый показатель, возводимый в степень точно;
+// большие показатели считаются во float64
+const maxExactExponent = 1024
+
+// ErrInvalidPrecision возвращается для неизвестного режима точности, округления или недопустимого масштаба
+var ErrInvalidPrecision = errors.New("invalid precision")
+
+// DecimalOptions масштаб и округление десятичного режима по умолчанию
+type DecimalOptions struct {
+	Scale    int
+	Rounding string
+}
+
+// IsExact сообщает, вычисляется ли выражение в режиме precision точно, а не во float64
+func IsExact(precision string) bool {
+	return precision != "" && precision != PrecisionFloat
+}
+
+// ApplyPrecision проверяет режим точности выражения и заполняет незаданные
+// масштаб и округление значениями defaults. Режим float приводится к пустому.
+// В режиме rational масштаб и округление задают приближения иррациональных
+// результатов и десятичную запись результата выражения.
+func ApplyPrecision(expr *models.Expression, defaults DecimalOptions) error {
+	switch expr.Precision {
+	case "", PrecisionFloat:
+		if expr.Scale != nil || expr.Rounding != "" {
+			return fmt.Errorf("%w: scale and rounding apply only to exact precisions", ErrInvalidPrecision)
+		}
+		expr.Precision = ""
+		return nil
+	case PrecisionDecimal, PrecisionRational:
+	default:
+		return fmt.Errorf("%w: unknown precision %q", ErrInvalidPrecision, expr.Precision)
+	}
+
+	if expr.Scale == nil {
+		scale := defaults.Scale
+		expr.Scale = &scale
+	}
+	if *expr.Scale < 0 || *expr.Scale > MaxScale {
+		return fmt.Errorf("%w: scale must be between 0 and %d", ErrInvalidPrecision, MaxScale)
+	}
+	if expr.Rounding == "" {
+		expr.Rounding = defaults.Rounding
+	}
+	if !ValidRounding(expr.Rounding) {
+		return fmt.Errorf("%w: unknown rounding %q", ErrInvalidPrecision, expr.Rounding)
+	}
+	return nil
+}
+
+// ValidRounding сообщает, известен ли режим округления
+func ValidRounding(mode string) bool {
+	switch mode {
+	case RoundHalfEven, RoundHalfUp, RoundHalfDown, RoundUp, RoundDown, RoundCeiling, RoundFloor:
+		return true
+	default:
+		return false
+	}
+}
+
+// CalculateExact выполняет операцию задачи в ее режиме точности над точными
+// аргументами ExactArg1, ExactArg2 и ExactArgs и возвращает точный результат строкой.
+// Ожидание и ошибки такие же, как у Calculate.
+func CalculateExact(ctx context.Context, task *models.Task) (string, error) {
+	if err := wait(ctx, time.Duration(task.OperationTime)*time.Millisecond); err != nil {
+		return "", err
+	}
+	if task.Precision != PrecisionDecimal && task.Precision != PrecisionRational {
+		return "", calculationError(task, fmt.Errorf("%w: %q", ErrInvalidPrecision, task.Precision))
+	}
+
+	var result *big.Rat
+	var err error
+	if fn, ok := LookupFunction(task.Operation); ok {
+		result, err = callExact(task, fn)
+	} else {
+		result, err = operateExact(task)
+	}
+	if err != nil {
+		return "", calculationError(task, err)
+	}
+
+	if _, err := Approximate(result); err != nil {
+		return "", calculationError(task, err)
+	}
+	return formatPrecision(result, task.Precision), nil
+}
+
+// formatPrecision записывает точное значение в виде режима precision:
+// несократимой дробью для rational, десятичной дробью для decimal
+func formatPrecision(x *big.Rat, precision string) string {
+	if precision == PrecisionRational {
+		return x.RatString()
+	}
+	return FormatExact(x)
+}
+
+// RenderExact разбирает точный результат s выражения или задачи в режиме
+// precision и возвращает его запись в этом режиме, десятичное приближение до
+// scale знаков (только для rational, в decimal результат и так десятичный)
+// и ближайшее значение float64
+func RenderExact(s, precision string, scale int, rounding string) (exact, decimal string, approx float64, err error) {
+	x, err := parseExact(s)
+	if err != nil {
+		return "", "", 0, err
+	}
+	if approx, err = Approximate(x); err != nil {
+		return "", "", 0, err
+	}
+	if precision == PrecisionRational {
+		decimal = FormatExact(roundRat(x, scale, rounding))
+	}
+	return formatPrecision(x, precision), decimal, approx, nil
+}
+
+// quotient округляет частное до Scale в режиме decimal; в режиме rational деление точное
+func quotient(task *models.Task, x *big.Rat) *big.Rat {
+	if task.Precision == PrecisionRational {
+		return x
+	}
+	return roundRat(x, task.Scale, task.Rounding)
+}
+
+// operateExact выполняет бинарную операцию задачи
+func operateExact(task *models.Task) (*big.Rat, error) {
+	a, err := parseExact(task.ExactArg1)
+	if err != nil {
+		return nil, err
+	}
+	b, err := parseExact(task.ExactArg2)
+	if err != nil {
+		return nil, err
+	}
+
+	switch task.Operation {
+	case "+":
+		return new(big.Rat).Add(a, b), nil
+	case "-":
+		return new(big.Rat).Sub(a, b), nil
+	case "*":
+		return new(big.Rat).Mul(a, b), nil
+	case "/":
+		if b.Sign() == 0 {
+			return nil, ErrDivisionByZero
+		}
+		return quotient(task, new(big.Rat).Quo(a, b)), nil
+	case "//":
+		if b.Sign() == 0 {
+			return nil, ErrDivisionByZero
+		}
+		return floorRat(new(big.Rat).Quo(a, b)), nil
+	case "%":
+		if b.Sign() == 0 {
+			return nil, ErrModuloByZero
+		}
+		// Остаток со знаком делимого, как в режиме float
+		q := truncRat(new(big.Rat).Quo(a, b))
+		return new(big.Rat).Sub(a, q.Mul(q, b)), nil
+	case "^":
+		return powExact(task, a, b)
+	default:
+		return nil, ErrUnknownOperator
+	}
+}
+
+// powExact возводит a в степень b; целые показатели считаются точно
+func powExact(task *models.Task, a, b *big.Rat) (*big.Rat, error) {
+	if !b.IsInt() || b.Num().CmpAbs(big.NewInt(maxExactExponent)) > 0 {
+		return viaFloat(task, func(args []float64) (float64, error) {
+			if args[0] == 0 && args[1] < 0 {
+				return 0, ErrDivisionByZero
+			}
+			return math.Pow(args[0], args[1]), nil
+		}, a, b)
+	}
+
+	n := b.Num().Int64()
+	if a.Sign() == 0 && n < 0 {
+		return nil, ErrDivisionByZero
+	}
+	abs := big.NewInt(n)
+	abs.Abs(abs)
+	num := new(big.Int).Exp(a.Num(), abs, nil)
+	den := new(big.Int).Exp(a.Denom(), abs, nil)
+	if n < 0 {
+		num, den = den, num
+	}
+	result := new(big.Rat).SetFrac(num, den)
+	if n < 0 {
+		return quotient(task, result), nil
+	}
+	return result, nil
+}
+
+// callExact вызывает встроенную функцию. Функции с точным результатом
+// считаются над big.Rat, трансцендентные - во float64 с округлением до Scale.
+func callExact(task *models.Task, fn *Function) (*big.Rat, error) {
+	if !fn.acceptsArgs(len(task.ExactArgs)) {
+		return nil, fmt.Errorf("%w: %d arguments, %s", ErrArgumentCount, len(task.ExactArgs), fn.arity())
+	}
+	args := make([]*big.Rat, len(task.ExactArgs))
+	for i, s := range task.ExactArgs {
+		var err error
+		if args[i], err = parseExact(s); err != nil {
+			return nil, err
+		}
+	}
+
+	switch fn.Name {
+	case "abs":
+		return new(big.Rat).Abs(args[0]), nil
+	case "min", "max":
+		result := args[0]
+		for _, arg := range args[1:] {
+			if c := arg.Cmp(result); (fn.Name == "min" && c < 0) || (fn.Name == "max" && c > 0) {
+				result = arg
+			}
+		}
+		return result, nil
+	case "floor":
+		return floorRat(args[0]), nil
+	case "ceil":
+		return new(big.Rat).Neg(floorRat(new(big.Rat).Neg(args[0]))), nil
+	case "round":
+		// Половины от нуля, как math.Round в режиме float
+		return roundRat(args[0], 0, RoundHalfUp), nil
+	case "sqrt":
+		return sqrtExact(task, args[0])
+	default:
+		return viaFloat(task, fn.Call, args...)
+	}
+}
+
+// sqrtExact извлекает корень с точностью, достаточной для округления до Scale
+func sqrtExact(task *models.Task, x *big.Rat) (*big.Rat, error) {
+	if x.Sign() < 0 {
+		return nil, ErrDomain
+	}
+	// Биты на целую часть, Scale знаков после запятой и запас на округление
+	digits := len(x.Num().String()) + task.Scale + 10
+	prec := uint(float64(digits)*math.Log2(10)) + 64
+	f := new(big.Float).SetPrec(prec).SetRat(x)
+	root, _ := f.Sqrt(f).Rat(nil)
+	return roundRat(root, task.Scale, task.Rounding), nil
+}
+
+// viaFloat вычисляет fn во float64 и округляет результат до Scale
+func viaFloat(task *models.Task, fn func([]float64) (float64, error), args ...*big.Rat) (*big.Rat, error) {
+	floats := make([]float64, len(args))
+	for i, arg := range args {
+		var err error
+		if floats[i], err = Approximate(arg); err != nil {
+			return nil, err
+		}
+	}
+	result, err := fn(floats)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := checkResult(task, result); err != nil {
+		return nil, errors.Unwrap(err)
+	}
+	return roundRat(new(big.Rat).SetFloat64(result), task.Scale, task.Rounding), nil
+}
+
+// parseExact разбирает точное значение аргумента
+func parseExact(s string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, fmt.Errorf("%w: invalid exact value %q", ErrInvalidPrecision, s)
+	}
+	return r, nil
+}
+
+// Approximate возвращает ближайшее к x значение float64; не представимое во float64 - ErrOverflow
+func Approximate(x *big.Rat) (float64, error) {
+	f, _ := x.Float64()
+	if math.IsInf(f, 0) {
+		return 0, ErrOverflow
+	}
+	return f, nil
+}
+
+// ApproximateExact как Approximate, но для значения, записанного строкой
+func ApproximateExact(s string) (float64, error) {
+	x, err := parseExact(s)
+	if err != nil {
+		return 0, err
+	}
+	return Approximate(x)
+}
+
+// FormatExact записывает конечную десятичную дробь x без потери знаков и
+// без лишних нулей: 0.3, -12, 0.0001
+func FormatExact(x *big.Rat) string {
+	places, _ := decimalPlaces(x)
+	return x.FloatString(places)
+}
+
+// formatRat записывает x конечной десятичной дробью, если она существует, и
+// обыкновенной (1/3) иначе
+func formatRat(x *big.Rat) string {
+	if places, ok := decimalPlaces(x); ok {
+		return x.FloatString(places)
+	}
+	return x.RatString()
+}
+
+// decimalPlaces возвращает число знаков после запятой в десятичной записи x и
+// конечна ли она
+func decimalPlaces(x *big.Rat) (int, bool) {
+	// Знаменатель конечной десятичной дроби состоит из двоек и пятерок, число
+	// знаков после запятой - наибольшая из их степеней
+	den := new(big.Int).Set(x.Denom())
+	places := 0
+	for _, p := range []int64{2, 5} {
+		n := 0
+		prime := big.NewInt(p)
+		m := new(big.Int)
+		for {
+			q, r := new(big.Int).QuoRem(den, prime, m)
+			if r.Sign() != 0 {
+				break
+			}
+			den = q
+			n++
+		}
+		places = max(places, n)
+	}
+	return places, den.IsInt64() && den.Int64() == 1
+}
+
+// roundRat округляет x до scale знаков после запятой в режиме mode
+func roundRat(x *big.Rat, scale int, mode string) *big.Rat {
+	pow := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)
+	scaled := new(big.Rat).Mul(x, new(big.Rat).SetInt(pow))
+
+	q, r := new(big.Int).QuoRem(scaled.Num(), scaled.Denom(), new(big.Int))
+	if r.Sign() != 0 {
+		// Сравнение отброшенной части с половиной: 2|r| против знаменателя
+		half := new(big.Int).Abs(r)
+		half.Lsh(half, 1)
+		cmp := half.Cmp(scaled.Denom())
+
+		var away bool
+		switch mode {
+		case RoundUp:
+			away = true
+		case RoundCeiling:
+			away = x.Sign() > 0
+		case RoundFloor:
+			away = x.Sign() < 0
+		case RoundHalfUp:
+			away = cmp >= 0
+		case RoundHalfDown:
+			away = cmp > 0
+		case RoundHalfEven:
+			away = cmp > 0 || (cmp == 0 && q.Bit(0) == 1)
+		}
+		if away {
+			q.Add(q, big.NewInt(int64(x.Sign())))
+		}
+	}
+	return new(big.Rat).SetFrac(q, pow)
+}
+
+// truncRat отбрасывает дробную часть x
+func truncRat(x *big.Rat) *big.Rat {
+	return new(big.Rat).SetInt(new(big.Int).Quo(x.Num(), x.Denom()))
+}
+
+// floorRat округляет x вниз до целого
+func floorRat(x *big.Rat) *big.Rat {
+	// Div в math/big - евклидово деление; знаменатель положителен, поэтому это floor
+	return new(big.Rat).SetInt(new(big.Int).Div(x.Num(), x.Denom()))
+}
@@ -0,0 +1,195 @@
+package calculator
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/m1tka051209/calculator-service/models"
+)
+
+func decimalTask(op string, args ...string) *models.Task {
+	task := &models.Task{Operation: op, Precision: PrecisionDecimal, Scale: 4, Rounding: RoundHalfEven}
+	if _, ok := LookupFunction(op); ok {
+		task.ExactArgs = args
+	} else {
+		task.ExactArg1, task.ExactArg2 = args[0], args[1]
+	}
+	return task
+}
+
+func TestCalculateExact(t *testing.T) {
+	tests := []struct {
+		name    string
+		task    *models.Task
+		want    string
+		wantErr error
+	}{
+		{"addition", decimalTask("+", "0.1", "0.2"), "0.3", nil},
+		{"subtraction", decimalTask("-", "1", "0.9"), "0.1", nil},
+		{"multiplication keeps digits", decimalTask("*", "1.05", "1.05"), "1.1025", nil},
+		{"division rounds to scale", decimalTask("/", "2", "3"), "0.6667", nil},
+		{"exact division", decimalTask("/", "1", "8"), "0.125", nil},
+		{"division by zero", decimalTask("/", "1", "0"), "", ErrDivisionByZero},
+		{"integer division", decimalTask("//", "-7", "2"), "-4", nil},
+		{"modulo", decimalTask("%", "-7.5", "2"), "-1.5", nil},
+		{"modulo by zero", decimalTask("%", "1", "0"), "", ErrModuloByZero},
+		{"integer power", decimalTask("^", "1.1", "3"), "1.331", nil},
+		{"negative power", decimalTask("^", "2", "-3"), "0.125", nil},
+		{"fractional power", decimalTask("^", "4", "0.5"), "2", nil},
+		{"zero to negative power", decimalTask("^", "0", "-1"), "", ErrDivisionByZero},
+		{"sqrt", decimalTask("sqrt", "2"), "1.4142", nil},
+		{"sqrt of negative", decimalTask("sqrt", "-2"), "", ErrDomain},
+		{"max", decimalTask("max", "0.1", "0.30", "0.2"), "0.3", nil},
+		{"round half away from zero", decimalTask("round", "-2.5"), "-3", nil},
+		{"ceil", decimalTask("ceil", "-1.5"), "-1", nil},
+		{"transcendental", decimalTask("ln", "1"), "0", nil},
+		{"bad argument", decimalTask("+", "x", "1"), "", ErrInvalidPrecision},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CalculateExact(context.Background(), tt.task)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CalculateExact() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("CalculateExact() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func rationalTask(op string, args ...string) *models.Task {
+	task := decimalTask(op, args...)
+	task.Precision = PrecisionRational
+	return task
+}
+
+func TestCalculateRational(t *testing.T) {
+	tests := []struct {
+		name string
+		task *models.Task
+		want string
+	}{
+		{"division is exact", rationalTask("/", "1", "3"), "1/3"},
+		{"fraction times integer", rationalTask("*", "1/3", "3"), "1"},
+		{"decimal argument", rationalTask("+", "0.1", "1/5"), "3/10"},
+		{"negative power", rationalTask("^", "2/3", "-2"), "9/4"},
+		{"integer division", rationalTask("//", "7/2", "1/3"), "10"},
+		{"modulo", rationalTask("%", "7/2", "1/3"), "1/6"},
+		{"min", rationalTask("min", "1/3", "0.3"), "3/10"},
+		{"irrational rounds to scale", rationalTask("sqrt", "2"), "7071/5000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CalculateExact(context.Background(), tt.task)
+			if err != nil {
+				t.Fatalf("CalculateExact() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("CalculateExact() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderExact(t *testing.T) {
+	exact, decimal, approx, err := RenderExact("2/6", PrecisionRational, 4, RoundHalfEven)
+	if err != nil {
+		t.Fatalf("RenderExact() error = %v", err)
+	}
+	if exact != "1/3" || decimal != "0.3333" || approx != 1.0/3 {
+		t.Errorf("RenderExact() = %q, %q, %v, want 1/3, 0.3333, %v", exact, decimal, approx, 1.0/3)
+	}
+
+	exact, decimal, _, err = RenderExact("0.50", PrecisionDecimal, 4, RoundHalfEven)
+	if err != nil || exact != "0.5" || decimal != "" {
+		t.Errorf("RenderExact() = %q, %q, %v, want 0.5 without decimal", exact, decimal, err)
+	}
+
+	if _, _, _, err := RenderExact("1/0", PrecisionRational, 4, RoundHalfEven); !errors.Is(err, ErrInvalidPrecision) {
+		t.Errorf("RenderExact(1/0) error = %v, want ErrInvalidPrecision", err)
+	}
+}
+
+func TestRoundRat(t *testing.T) {
+	tests := []struct {
+		x    string
+		mode string
+		want string
+	}{
+		{"2.5", RoundHalfEven, "2"},
+		{"3.5", RoundHalfEven, "4"},
+		{"-2.5", RoundHalfEven, "-2"},
+		{"2.5", RoundHalfUp, "3"},
+		{"-2.5", RoundHalfUp, "-3"},
+		{"2.5", RoundHalfDown, "2"},
+		{"2.6", RoundHalfDown, "3"},
+		{"2.1", RoundUp, "3"},
+		{"-2.1", RoundUp, "-3"},
+		{"2.9", RoundDown, "2"},
+		{"-2.9", RoundDown, "-2"},
+		{"2.1", RoundCeiling, "3"},
+		{"-2.9", RoundCeiling, "-2"},
+		{"2.9", RoundFloor, "2"},
+		{"-2.1", RoundFloor, "-3"},
+		{"7", RoundUp, "7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.x+" "+tt.mode, func(t *testing.T) {
+			x, _ := new(big.Rat).SetString(tt.x)
+			if got := FormatExact(roundRat(x, 0, tt.mode)); got != tt.want {
+				t.Errorf("roundRat(%s, 0, %s) = %s, want %s", tt.x, tt.mode, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecomposeExact(t *testing.T) {
+	plan, err := DecomposeExact("-0.1 + 2e-1")
+	if err != nil {
+		t.Fatalf("DecomposeExact() error = %v", err)
+	}
+	if len(plan.Tasks) != 1 {
+		t.Fatalf("DecomposeExact() produced %d tasks, want 1", len(plan.Tasks))
+	}
+	if task := plan.Tasks[0]; task.ExactArg1 != "-0.1" || task.ExactArg2 != "0.2" {
+		t.Errorf("task exact args = %q, %q, want -0.1, 0.2", task.ExactArg1, task.ExactArg2)
+	}
+
+	plan, err = DecomposeExact("-(1.50)")
+	if err != nil {
+		t.Fatalf("DecomposeExact() error = %v", err)
+	}
+	if plan.ExactResult != "-1.5" {
+		t.Errorf("ExactResult = %q, want -1.5", plan.ExactResult)
+	}
+}
+
+func TestApplyPrecision(t *testing.T) {
+	defaults := DecimalOptions{Scale: 10, Rounding: RoundHalfEven}
+
+	expr := &models.Expression{Precision: PrecisionDecimal}
+	if err := ApplyPrecision(expr, defaults); err != nil {
+		t.Fatalf("ApplyPrecision() error = %v", err)
+	}
+	if *expr.Scale != 10 || expr.Rounding != RoundHalfEven {
+		t.Errorf("defaults not applied: scale %d, rounding %q", *expr.Scale, expr.Rounding)
+	}
+
+	scale := -1
+	for _, expr := range []*models.Expression{
+		{Precision: "double"},
+		{Precision: PrecisionDecimal, Rounding: "nearest"},
+		{Precision: PrecisionDecimal, Scale: &scale},
+		{Rounding: RoundHalfUp},
+	} {
+		if err := ApplyPrecision(expr, defaults); !errors.Is(err, ErrInvalidPrecision) {
+			t.Errorf("ApplyPrecision(%+v) error = %v, want ErrInvalidPrecision", expr, err)
+		}
+	}
+}
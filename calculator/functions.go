@@ -0,0 +1,99 @@
+package calculator
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+// Function встроенная функция, вызываемая в выражении как name(arg, ...)
+type Function struct {
+	Name string
+	// MinArgs и MaxArgs допустимое число аргументов; MaxArgs < 0 - без ограничения
+	MinArgs, MaxArgs int
+	// Call вычисляет функцию; ошибка области определения возвращается как ErrDomain
+	Call func(args []float64) (float64, error)
+}
+
+// acceptsArgs сообщает, можно ли вызвать функцию с n аргументами
+func (f *Function) acceptsArgs(n int) bool {
+	return n >= f.MinArgs && (f.MaxArgs < 0 || n <= f.MaxArgs)
+}
+
+// arity описывает допустимое число аргументов для сообщений об ошибках
+func (f *Function) arity() string {
+	switch {
+	case f.MaxArgs < 0:
+		return fmt.Sprintf("expected at least %d", f.MinArgs)
+	case f.MinArgs == f.MaxArgs:
+		return fmt.Sprintf("expected %d", f.MinArgs)
+	default:
+		return fmt.Sprintf("expected %d to %d", f.MinArgs, f.MaxArgs)
+	}
+}
+
+// unary оборачивает функцию одного аргумента
+func unary(name string, fn func(float64) float64) *Function {
+	return &Function{Name: name, MinArgs: 1, MaxArgs: 1, Call: func(args []float64) (float64, error) {
+		return fn(args[0]), nil
+	}}
+}
+
+// positive оборачивает функцию, определенную только для положительного аргумента
+func positive(name string, fn func(float64) float64) *Function {
+	return &Function{Name: name, MinArgs: 1, MaxArgs: 1, Call: func(args []float64) (float64, error) {
+		if args[0] <= 0 {
+			return 0, ErrDomain
+		}
+		return fn(args[0]), nil
+	}}
+}
+
+// functions реестр встроенных функций по имени
+var functions = map[string]*Function{}
+
+func init() {
+	for _, f := range []*Function{
+		{Name: "sqrt", MinArgs: 1, MaxArgs: 1, Call: func(args []float64) (float64, error) {
+			if args[0] < 0 {
+				return 0, ErrDomain
+			}
+			return math.Sqrt(args[0]), nil
+		}},
+		unary("abs", math.Abs),
+		positive("ln", math.Log),
+		positive("log10", math.Log10),
+		unary("exp", math.Exp),
+		unary("sin", math.Sin),
+		unary("cos", math.Cos),
+		unary("tan", math.Tan),
+		// round округляет половины от нуля: round(-2.5) = -3
+		unary("round", math.Round),
+		unary("floor", math.Floor),
+		unary("ceil", math.Ceil),
+		{Name: "min", MinArgs: 1, MaxArgs: -1, Call: func(args []float64) (float64, error) {
+			return slices.Min(args), nil
+		}},
+		{Name: "max", MinArgs: 1, MaxArgs: -1, Call: func(args []float64) (float64, error) {
+			return slices.Max(args), nil
+		}},
+	} {
+		functions[f.Name] = f
+	}
+}
+
+// LookupFunction возвращает встроенную функцию по имени
+func LookupFunction(name string) (*Function, bool) {
+	f, ok := functions[name]
+	return f, ok
+}
+
+// FunctionNames имена всех встроенных функций в алфавитном порядке
+func FunctionNames() []string {
+	names := make([]string, 0, len(functions))
+	for name := range functions {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,136 @@
+package calculator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// tokenKind вид лексемы выражения
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenOperator
+	tokenLParen
+	tokenRParen
+	tokenComma
+	tokenIdent
+)
+
+// token лексема с позицией в исходной строке
+type token struct {
+	kind  tokenKind
+	text  string
+	value float64
+	pos   int
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of expression"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// tokenize разбивает выражение на лексемы, пропуская пробельные символы.
+// Позиции лексем считаются в байтах от начала строки.
+func tokenize(expr string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(expr); {
+		c, size := utf8.DecodeRuneInString(expr[i:])
+		switch {
+		case unicode.IsSpace(c):
+			i += size
+		case isDigit(c) || c == '.':
+			start := i
+			i = scanNumber(expr, i)
+			value, err := strconv.ParseFloat(expr[start:i], 64)
+			if err != nil {
+				return nil, newParseError(expr, start, nil, "invalid number %q", expr[start:i])
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: expr[start:i], value: value, pos: start})
+		case strings.HasPrefix(expr[i:], "//"):
+			tokens = append(tokens, token{kind: tokenOperator, text: "//", pos: i})
+			i += 2
+		case isOperator(c):
+			tokens = append(tokens, token{kind: tokenOperator, text: string(c), pos: i})
+			i += size
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenLParen, text: "(", pos: i})
+			i += size
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenRParen, text: ")", pos: i})
+			i += size
+		case c == ',':
+			tokens = append(tokens, token{kind: tokenComma, text: ",", pos: i})
+			i += size
+		case isIdentStart(c):
+			start := i
+			for i < len(expr) {
+				c, size := utf8.DecodeRuneInString(expr[i:])
+				if !isIdentStart(c) && !isDigit(c) {
+					break
+				}
+				i += size
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: expr[start:i], pos: start})
+		default:
+			return nil, newParseError(expr, i, nil, "unexpected character %q", c)
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF, pos: len(expr)}), nil
+}
+
+// scanNumber возвращает конец числового литерала, начинающегося с i:
+// цифры с необязательной дробной частью (1, 1.5, .5, 1.) и порядком (1e3, 1.5e-3).
+// Некорректный литерал вроде "1.2.3" или "1e" целиком попадает в результат,
+// чтобы ParseFloat сообщил о нем как о неверном числе.
+func scanNumber(expr string, i int) int {
+	digits := func() {
+		for i < len(expr) && isDigit(rune(expr[i])) {
+			i++
+		}
+	}
+
+	digits()
+	if i < len(expr) && expr[i] == '.' {
+		i++
+		digits()
+	}
+	if i < len(expr) && (expr[i] == 'e' || expr[i] == 'E') {
+		i++
+		if i < len(expr) && (expr[i] == '+' || expr[i] == '-') {
+			i++
+		}
+		digits()
+	}
+	// Хвост из цифр и точек (1.2.3) относится к тому же неверному литералу
+	for i < len(expr) && (isDigit(rune(expr[i])) || expr[i] == '.') {
+		i++
+	}
+	return i
+}
+
+func isDigit(c rune) bool {
+	return c >= '0' && c <= '9'
+}
+
+// isIdentStart сообщает, может ли c начинать имя (функции): буква или подчеркивание
+func isIdentStart(c rune) bool {
+	return c == '_' || unicode.IsLetter(c)
+}
+
+func isOperator(c rune) bool {
+	switch c {
+	case '+', '-', '*', '/', '%', '^':
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,289 @@
+package calculator
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/m1tka051209/calculator-service/models"
+)
+
+// Optimizations упрощения выражения перед разложением на задачи. Каждое из них
+// уменьшает число задач, не меняя результата вычислимого выражения.
+type Optimizations struct {
+	// Fold вычисляет операции над числами сразу, без задач: 2*3 -> 6.
+	// Операция, которая завершилась бы ошибкой (1/0), остается задачей,
+	// чтобы ошибка пришла обычным путем.
+	Fold bool
+	// Identities убирает операции, не меняющие аргумент: x+0, 0+x, x-0, x*1, 1*x, x/1, x^1 -> x
+	Identities bool
+	// ShortCircuit заменяет числом операции, результат которых не зависит от
+	// второго аргумента: 0*x, x*0 -> 0 и x^0, 1^x -> 1, вместе с задачами всего
+	// подвыражения x: 0*(2+3) -> 0. Подвыражение отбрасывается, только если оно
+	// вычисляется без ошибки (значения всех имен известны при разложении), иначе
+	// 0*(1/0) и sqrt(-1)*0 давали бы 0 вместо ошибки; такое подвыражение остается задачами.
+	ShortCircuit bool
+}
+
+// Названия упрощений в ParseOptimizations
+const (
+	OptimizeFold         = "fold"
+	OptimizeIdentities   = "identities"
+	OptimizeShortCircuit = "short_circuit"
+)
+
+// ParseOptimizations разбирает список упрощений через запятую: "fold,identities",
+// "all" для всех или "none" и пустую строку для отключения упрощений
+func ParseOptimizations(s string) (Optimizations, error) {
+	var o Optimizations
+	for _, name := range strings.Split(s, ",") {
+		switch strings.TrimSpace(name) {
+		case "", "none":
+		case "all":
+			o = Optimizations{Fold: true, Identities: true, ShortCircuit: true}
+		case OptimizeFold:
+			o.Fold = true
+		case OptimizeIdentities:
+			o.Identities = true
+		case OptimizeShortCircuit:
+			o.ShortCircuit = true
+		default:
+			return Optimizations{}, fmt.Errorf("unknown optimization %q, expected %s, %s, %s, all or none",
+				name, OptimizeFold, OptimizeIdentities, OptimizeShortCircuit)
+		}
+	}
+	return o, nil
+}
+
+func (o Optimizations) enabled() bool {
+	return o.Fold || o.Identities || o.ShortCircuit
+}
+
+// optimizer упрощает разобранное дерево, в котором уже подставлены функции
+type optimizer struct {
+	Optimizations
+	// task образец задачи для свертки: режим точности, масштаб и округление выражения
+	task models.Task
+	vars map[string]float64
+	// done упрощенные узлы: общий аргумент подставленной функции упрощается один раз
+	// и остается общим
+	done map[Node]Node
+	// values значения подвыражений для ShortCircuit; nil - вычисление дает ошибку
+	values map[Node]*NumberNode
+}
+
+func newOptimizer(opts Options, vars map[string]float64) *optimizer {
+	return &optimizer{
+		Optimizations: opts.Optimize,
+		task:          models.Task{Precision: opts.Precision, Scale: opts.Scale, Rounding: opts.Rounding},
+		vars:          vars,
+		done:          map[Node]Node{},
+		values:        map[Node]*NumberNode{},
+	}
+}
+
+func (o *optimizer) optimize(n Node) Node {
+	if out, ok := o.done[n]; ok {
+		return out
+	}
+	out := o.optimizeNode(n)
+	o.done[n] = out
+	return out
+}
+
+func (o *optimizer) optimizeNode(n Node) Node {
+	switch n := n.(type) {
+	case *UnaryNode:
+		operand := o.optimize(n.Operand)
+		if n.Op == "+" {
+			return operand
+		}
+		// Знак числа планировщик учитывает и так; здесь он нужен, чтобы -2 было числом для свертки
+		if num, ok := o.number(operand); ok {
+			return o.negate(num)
+		}
+		return &UnaryNode{Op: n.Op, Operand: operand}
+	case *BinaryNode:
+		left, right := o.optimize(n.Left), o.optimize(n.Right)
+		if out := o.binary(n.Op, left, right); out != nil {
+			return out
+		}
+		return &BinaryNode{Op: n.Op, Left: left, Right: right}
+	case *CallNode:
+		call := &CallNode{Name: n.Name, Args: make([]Node, len(n.Args)), Pos: n.Pos}
+		for i, arg := range n.Args {
+			call.Args[i] = o.optimize(arg)
+		}
+		if o.Fold {
+			if out := o.fold(call.Name, call.Args...); out != nil {
+				return out
+			}
+		}
+		return call
+	default:
+		return n
+	}
+}
+
+// binary упрощает операцию op над уже упрощенными аргументами; nil - упрощать нечего
+func (o *optimizer) binary(op string, left, right Node) Node {
+	if o.Fold {
+		if out := o.fold(op, left, right); out != nil {
+			return out
+		}
+	}
+	if o.ShortCircuit {
+		switch {
+		case op == "*" && ((o.is(left, 0) && o.safe(right)) || (o.is(right, 0) && o.safe(left))):
+			return o.literal(0)
+		case op == "^" && ((o.is(right, 0) && o.safe(left)) || (o.is(left, 1) && o.safe(right))):
+			return o.literal(1)
+		}
+	}
+	if o.Identities {
+		switch {
+		case (op == "+" || op == "-") && o.is(right, 0),
+			(op == "*" || op == "/" || op == "^") && o.is(right, 1):
+			return left
+		case (op == "+" && o.is(left, 0)) || (op == "*" && o.is(left, 1)):
+			return right
+		}
+	}
+	return nil
+}
+
+// fold вычисляет операцию op, если все ее аргументы - числа, так же, как это
+// сделал бы воркер. Если аргумент не число или вычисление дает ошибку, возвращает nil.
+func (o *optimizer) fold(op string, args ...Node) Node {
+	nums := make([]*NumberNode, len(args))
+	for i, arg := range args {
+		num, ok := o.number(arg)
+		if !ok {
+			return nil
+		}
+		nums[i] = num
+	}
+
+	task := o.task
+	task.Operation = op
+	if _, ok := LookupFunction(op); ok {
+		task.Args = make([]float64, len(nums))
+		task.ExactArgs = make([]string, len(nums))
+		for i, num := range nums {
+			task.Args[i], task.ExactArgs[i] = num.Value, num.Text
+		}
+	} else {
+		task.Arg1, task.ExactArg1 = nums[0].Value, nums[0].Text
+		task.Arg2, task.ExactArg2 = nums[1].Value, nums[1].Text
+	}
+
+	if !IsExact(task.Precision) {
+		result, err := Calculate(context.Background(), &task)
+		if err != nil {
+			return nil
+		}
+		return &NumberNode{Value: result, Text: strconv.FormatFloat(result, 'g', -1, 64)}
+	}
+	exact, err := CalculateExact(context.Background(), &task)
+	if err != nil {
+		return nil
+	}
+	value, err := ApproximateExact(exact)
+	if err != nil {
+		return nil
+	}
+	return &NumberNode{Value: value, Text: exact}
+}
+
+// number возвращает значение узла, если это число или имя с известным значением
+func (o *optimizer) number(n Node) (*NumberNode, bool) {
+	switch n := n.(type) {
+	case *NumberNode:
+		return n, true
+	case *VariableNode:
+		value, ok := o.vars[n.Name]
+		if !ok {
+			return nil, false
+		}
+		return o.literal(value).(*NumberNode), true
+	default:
+		return nil, false
+	}
+}
+
+// safe сообщает, что узел можно отбросить: его вычисление не завершится ошибкой
+func (o *optimizer) safe(n Node) bool {
+	return o.value(n) != nil
+}
+
+// value вычисляет подвыражение так же, как это сделали бы воркеры; nil, если
+// какая-то его операция завершится ошибкой
+func (o *optimizer) value(n Node) *NumberNode {
+	if num, ok := o.values[n]; ok {
+		return num
+	}
+	num := o.evaluate(n)
+	o.values[n] = num
+	return num
+}
+
+func (o *optimizer) evaluate(n Node) *NumberNode {
+	if num, ok := o.number(n); ok {
+		return num
+	}
+	var op string
+	var args []Node
+	switch n := n.(type) {
+	case *UnaryNode:
+		operand := o.value(n.Operand)
+		if operand == nil || n.Op == "+" {
+			return operand
+		}
+		return o.negate(operand).(*NumberNode)
+	case *BinaryNode:
+		op, args = n.Op, []Node{n.Left, n.Right}
+	case *CallNode:
+		op, args = n.Name, n.Args
+	default:
+		return nil
+	}
+	nums := make([]Node, len(args))
+	for i, arg := range args {
+		num := o.value(arg)
+		if num == nil {
+			return nil
+		}
+		nums[i] = num
+	}
+	num, _ := o.fold(op, nums...).(*NumberNode)
+	return num
+}
+
+// is сообщает, что узел - число, точно равное v
+func (o *optimizer) is(n Node, v int64) bool {
+	num, ok := o.number(n)
+	if !ok {
+		return false
+	}
+	if IsExact(o.task.Precision) {
+		x, ok := new(big.Rat).SetString(num.Text)
+		return ok && x.Cmp(big.NewRat(v, 1)) == 0
+	}
+	return num.Value == float64(v)
+}
+
+// literal число v в виде узла
+func (o *optimizer) literal(v float64) Node {
+	return &NumberNode{Value: v, Text: strconv.FormatFloat(v, 'g', -1, 64)}
+}
+
+// negate число с противоположным знаком
+func (o *optimizer) negate(num *NumberNode) Node {
+	neg := &NumberNode{Value: -num.Value, Text: "-" + num.Text}
+	if x, ok := new(big.Rat).SetString(num.Text); ok {
+		neg.Text = formatRat(x.Neg(x))
+	}
+	return neg
+}
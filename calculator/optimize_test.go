@@ -0,0 +1,150 @@
+package calculator
+
+import (
+	"context"
+	"testing"
+)
+
+func TestOptimize(t *testing.T) {
+	all := Optimizations{Fold: true, Identities: true, ShortCircuit: true}
+	tests := []struct {
+		name       string
+		expr       string
+		optimize   Optimizations
+		want       float64
+		tasks      int
+		eliminated int
+	}{
+		{"fold", "2+2*2", Optimizations{Fold: true}, 6, 0, 2},
+		{"fold functions", "max(1, sqrt(16), -2*3)", Optimizations{Fold: true}, 4, 0, 3},
+		{"fold variables and constants", "2*rate + e - e", Optimizations{Fold: true}, 1, 0, 3},
+		{"identity", "(2+3)*1", Optimizations{Identities: true}, 5, 1, 1},
+		{"identity on the left", "0 + 1*(2+3) - 0", Optimizations{Identities: true}, 5, 1, 3},
+		{"identity power", "(2+3)^1/1", Optimizations{Identities: true}, 5, 1, 2},
+		{"zero times", "0*rate + (2+3)", Optimizations{ShortCircuit: true}, 5, 2, 1},
+		{"times zero", "rate*0 + 1", Optimizations{ShortCircuit: true}, 1, 1, 1},
+		{"zero power", "rate^0", Optimizations{ShortCircuit: true}, 1, 0, 1},
+		{"one to power", "1^rate", Optimizations{ShortCircuit: true}, 1, 0, 1},
+		{"short circuit drops subexpression", "0*(2+3)", Optimizations{ShortCircuit: true}, 0, 0, 2},
+		{"short circuit drops functions", "(sqrt(16)+rate)^0 + 1", Optimizations{ShortCircuit: true}, 2, 1, 3},
+		{"short circuit after fold", "0*(sqrt(16)+1)", all, 0, 0, 3},
+		{"disabled", "2+2*2", Optimizations{}, 6, 2, 0},
+		{"all", "sq(1+2) * (4 - 3)", all, 9, 0, 4},
+	}
+
+	opts := Options{
+		Variables: map[string]float64{"rate": 0.5},
+		Functions: userFunctions(t, "sq(x) = x*x"),
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts.Optimize = tt.optimize
+			plan, err := DecomposeWith(tt.expr, opts)
+			if err != nil {
+				t.Fatalf("DecomposeWith() error = %v", err)
+			}
+			if len(plan.Tasks) != tt.tasks || plan.Eliminated != tt.eliminated {
+				t.Errorf("DecomposeWith() produced %d tasks, eliminated %d, want %d and %d",
+					len(plan.Tasks), plan.Eliminated, tt.tasks, tt.eliminated)
+			}
+			if got := evalPlan(t, plan); got != tt.want {
+				t.Errorf("result = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOptimizeKeepsErrors(t *testing.T) {
+	// Деление на ноль не сворачивается, чтобы выражение завершилось ошибкой через задачу
+	plan, err := DecomposeWith("1/0 + 2*3", Options{Optimize: Optimizations{Fold: true}})
+	if err != nil {
+		t.Fatalf("DecomposeWith() error = %v", err)
+	}
+	if len(plan.Tasks) != 2 || plan.Tasks[0].Operation != "/" || plan.Tasks[1].Arg2 != 6 {
+		t.Errorf("DecomposeWith() tasks = %+v, want 1/0 and <1/0>+6", plan.Tasks)
+	}
+}
+
+func TestShortCircuitKeepsErrors(t *testing.T) {
+	// Отбрасываемое подвыражение может завершиться ошибкой, поэтому оно остается задачей
+	all := Optimizations{Fold: true, Identities: true, ShortCircuit: true}
+	for _, tt := range []struct{ expr, op string }{
+		{"0*(1/0)", "/"},
+		{"sqrt(-1)*0", "sqrt"},
+		{"(1/0)^0", "/"},
+		{"1^sqrt(-1)", "sqrt"},
+		{"0*(1e308*10)", "*"},
+	} {
+		t.Run(tt.expr, func(t *testing.T) {
+			plan, err := DecomposeWith(tt.expr, Options{Optimize: all})
+			if err != nil {
+				t.Fatalf("DecomposeWith() error = %v", err)
+			}
+			if len(plan.Tasks) != 2 || plan.Tasks[0].Operation != tt.op {
+				t.Fatalf("DecomposeWith() tasks = %+v, want %s and the operation using it", plan.Tasks, tt.op)
+			}
+			if _, err := Calculate(context.Background(), &plan.Tasks[0]); err == nil {
+				t.Errorf("Calculate(%s) succeeded, want an error", tt.op)
+			}
+		})
+	}
+}
+
+func TestOptimizeExact(t *testing.T) {
+	fold := Optimizations{Fold: true}
+	tests := []struct {
+		precision string
+		expr      string
+		want      string
+	}{
+		{PrecisionDecimal, "0.1 + 0.2", "0.3"},
+		{PrecisionDecimal, "1/3*3", "0.9999"},
+		{PrecisionRational, "1/3*3", "1"},
+		{PrecisionRational, "-(1/3)", "-1/3"},
+		{PrecisionRational, "2^-2 + 1/6", "5/12"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.precision+" "+tt.expr, func(t *testing.T) {
+			plan, err := DecomposeWith(tt.expr, Options{Precision: tt.precision, Scale: 4, Rounding: RoundHalfEven, Optimize: fold})
+			if err != nil {
+				t.Fatalf("DecomposeWith() error = %v", err)
+			}
+			if len(plan.Tasks) != 0 || plan.ExactResult != tt.want {
+				t.Errorf("DecomposeWith() = %d tasks, exact result %q, want %q", len(plan.Tasks), plan.ExactResult, tt.want)
+			}
+		})
+	}
+
+	// Задача получает свернутый аргумент точной дробью, а не ее округлением
+	plan, err := DecomposeWith("1/3 + 1/0", Options{
+		Precision: PrecisionRational, Scale: 4, Rounding: RoundHalfEven, Optimize: fold,
+	})
+	if err != nil {
+		t.Fatalf("DecomposeWith() error = %v", err)
+	}
+	if len(plan.Tasks) != 2 || plan.Tasks[1].ExactArg1 != "1/3" {
+		t.Errorf("DecomposeWith() tasks = %+v, want 1/0 and 1/3+<1/0>", plan.Tasks)
+	}
+}
+
+func TestParseOptimizations(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Optimizations
+	}{
+		{"", Optimizations{}},
+		{"none", Optimizations{}},
+		{"all", Optimizations{Fold: true, Identities: true, ShortCircuit: true}},
+		{"fold, short_circuit", Optimizations{Fold: true, ShortCircuit: true}},
+		{"identities", Optimizations{Identities: true}},
+	}
+	for _, tt := range tests {
+		got, err := ParseOptimizations(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseOptimizations(%q) = %+v, %v, want %+v", tt.in, got, err, tt.want)
+		}
+	}
+	if _, err := ParseOptimizations("fold,inline"); err == nil {
+		t.Error("ParseOptimizations(fold,inline) error = nil, want error")
+	}
+}
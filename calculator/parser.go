@@ -0,0 +1,241 @@
+package calculator
+
+import "errors"
+
+// ErrInvalidExpression возвращается, если выражение не удалось разобрать
+var ErrInvalidExpression = errors.New("invalid expression")
+
+// Node узел синтаксического дерева выражения
+type Node interface {
+	node()
+}
+
+// NumberNode числовой литерал
+type NumberNode struct {
+	Value float64
+	// Text литерал как он записан в выражении, для точных режимов
+	Text string
+}
+
+// BinaryNode бинарная операция над двумя подвыражениями
+type BinaryNode struct {
+	Op    string
+	Left  Node
+	Right Node
+}
+
+// UnaryNode унарный плюс или минус перед подвыражением
+type UnaryNode struct {
+	Op      string
+	Operand Node
+}
+
+// CallNode вызов встроенной или пользовательской функции
+type CallNode struct {
+	Name string
+	Args []Node
+	// Pos позиция имени в выражении, для сообщений об ошибках вызова
+	Pos int
+}
+
+// VariableNode имя переменной или константы; значение подставляется при разложении
+type VariableNode struct {
+	Name string
+	// Pos позиция имени в выражении, для сообщения о неизвестной переменной
+	Pos int
+}
+
+func (*NumberNode) node()   {}
+func (*BinaryNode) node()   {}
+func (*UnaryNode) node()    {}
+func (*CallNode) node()     {}
+func (*VariableNode) node() {}
+
+// binaryPrecedence приоритеты левоассоциативных бинарных операторов: чем больше,
+// тем раньше выполняется. Возведение в степень ^ сильнее всех и разбирается
+// отдельно в parsePower, так как оно правоассоциативно.
+var binaryPrecedence = map[string]int{
+	"+":  1,
+	"-":  1,
+	"*":  2,
+	"/":  2,
+	"//": 2,
+	"%":  2,
+}
+
+// Parse разбирает выражение в синтаксическое дерево с учетом приоритета операций,
+// скобок, унарных плюса и минуса, вызовов функций и имен переменных. Число
+// аргументов встроенных функций проверяется сразу, а существование переменных и
+// пользовательских функций - при разложении. Ошибки возвращаются как *ParseError.
+// Грамматика:
+//
+//	expr    = unary { binop unary }
+//	unary   = ( "+" | "-" ) unary | power
+//	power   = primary [ "^" unary ]
+//	primary = number | "(" expr ")" | call | name
+//	call    = name "(" [ expr { "," expr } ] ")"
+func Parse(expr string) (Node, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{input: expr, tokens: tokens}
+	root, err := p.parseBinary(1)
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, p.unexpected(tok, "operator", "end of expression")
+	}
+	return root, nil
+}
+
+type parser struct {
+	input  string
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// parseBinary разбирает цепочку бинарных операций с приоритетом не ниже minPrec
+// (метод восхождения по приоритетам, все операторы левоассоциативны)
+func (p *parser) parseBinary(minPrec int) (Node, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		tok := p.peek()
+		prec, ok := binaryPrecedence[tok.text]
+		if tok.kind != tokenOperator || !ok || prec < minPrec {
+			return left, nil
+		}
+		p.next()
+
+		right, err := p.parseBinary(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryNode{Op: tok.text, Left: left, Right: right}
+	}
+}
+
+// parseOperand разбирает операнд бинарной операции: унарные знаки связывают
+// сильнее бинарных операторов, кроме ^, поэтому -2*3 это (-2)*3, а -2^2 это -(2^2)
+func (p *parser) parseOperand() (Node, error) {
+	tok := p.peek()
+	if tok.kind == tokenOperator && (tok.text == "+" || tok.text == "-") {
+		p.next()
+		operand, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryNode{Op: tok.text, Operand: operand}, nil
+	}
+	return p.parsePower()
+}
+
+// parsePower разбирает степень: показатель сам может быть степенью, поэтому
+// 2^3^2 это 2^(3^2), и может иметь знак: 2^-1
+func (p *parser) parsePower() (Node, error) {
+	base, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenOperator || tok.text != "^" {
+		return base, nil
+	}
+	p.next()
+
+	exponent, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return &BinaryNode{Op: "^", Left: base, Right: exponent}, nil
+}
+
+func (p *parser) parsePrimary() (Node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokenNumber:
+		return &NumberNode{Value: tok.value, Text: tok.text}, nil
+	case tokenLParen:
+		inner, err := p.parseBinary(1)
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokenRParen {
+			return nil, p.errorf(closing, []string{"operator", `")"`},
+				"unexpected %s, \"(\" at position %d is not closed", closing, tok.pos)
+		}
+		return inner, nil
+	case tokenIdent:
+		// Имя встроенной функции без скобок - ошибка вызова, а не переменная
+		if _, ok := LookupFunction(tok.text); ok || p.peek().kind == tokenLParen {
+			return p.parseCall(tok)
+		}
+		return &VariableNode{Name: tok.text, Pos: tok.pos}, nil
+	default:
+		return nil, p.unexpected(tok, expectOperand...)
+	}
+}
+
+// parseCall разбирает аргументы вызова функции name и, если функция встроенная,
+// проверяет их число
+func (p *parser) parseCall(name token) (Node, error) {
+	if tok := p.next(); tok.kind != tokenLParen {
+		return nil, p.errorf(tok, []string{`"("`}, "unexpected %s after function %q", tok, name.text)
+	}
+
+	call := &CallNode{Name: name.text, Pos: name.pos}
+	if p.peek().kind == tokenRParen {
+		p.next()
+	} else {
+		for {
+			arg, err := p.parseBinary(1)
+			if err != nil {
+				return nil, err
+			}
+			call.Args = append(call.Args, arg)
+
+			tok := p.next()
+			if tok.kind == tokenRParen {
+				break
+			}
+			if tok.kind != tokenComma {
+				return nil, p.errorf(tok, []string{"operator", `","`, `")"`},
+					"unexpected %s in call of %q", tok, name.text)
+			}
+		}
+	}
+
+	if fn, ok := LookupFunction(name.text); ok && !fn.acceptsArgs(len(call.Args)) {
+		return nil, p.errorf(name, nil, "function %q called with %d arguments, %s", name.text, len(call.Args), fn.arity())
+	}
+	return call, nil
+}
+
+// expectOperand что может стоять на месте операнда
+var expectOperand = []string{"number", "name", `"("`, `"+"`, `"-"`}
+
+// errorf возвращает *ParseError на месте лексемы tok
+func (p *parser) errorf(tok token, expected []string, format string, args ...interface{}) error {
+	return newParseError(p.input, tok.pos, expected, format, args...)
+}
+
+func (p *parser) unexpected(tok token, expected ...string) error {
+	return p.errorf(tok, expected, "unexpected %s", tok)
+}
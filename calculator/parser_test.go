@@ -0,0 +1,206 @@
+package calculator
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/m1tka051209/calculator-service/models"
+)
+
+func TestDecompose(t *testing.T) {
+	plan, err := Decompose("2 + 2 * 2")
+	if err != nil {
+		t.Fatalf("Decompose() error = %v", err)
+	}
+	if len(plan.Tasks) != 2 {
+		t.Fatalf("Decompose() produced %d tasks, want 2", len(plan.Tasks))
+	}
+
+	mul, add := plan.Tasks[0], plan.Tasks[1]
+	if mul.Operation != "*" || mul.Arg1 != 2 || mul.Arg2 != 2 || mul.Arg1TaskID != "" || mul.Arg2TaskID != "" {
+		t.Errorf("first task = %+v, want 2*2 without dependencies", mul)
+	}
+	if add.Operation != "+" || add.Arg1 != 2 || add.Arg1TaskID != "" || add.Arg2TaskID != mul.ID {
+		t.Errorf("second task = %+v, want 2+<%s>", add, mul.ID)
+	}
+}
+
+func TestDecomposeLeftAssociative(t *testing.T) {
+	plan, err := Decompose("8-3-2")
+	if err != nil {
+		t.Fatalf("Decompose() error = %v", err)
+	}
+	if len(plan.Tasks) != 2 {
+		t.Fatalf("Decompose() produced %d tasks, want 2", len(plan.Tasks))
+	}
+
+	first, second := plan.Tasks[0], plan.Tasks[1]
+	if first.Arg1 != 8 || first.Arg2 != 3 {
+		t.Errorf("first task = %+v, want 8-3", first)
+	}
+	if second.Arg1TaskID != first.ID || second.Arg2 != 2 {
+		t.Errorf("second task = %+v, want <%s>-2", second, first.ID)
+	}
+}
+
+func TestDecomposeNumber(t *testing.T) {
+	plan, err := Decompose(" 42 ")
+	if err != nil {
+		t.Fatalf("Decompose() error = %v", err)
+	}
+	if len(plan.Tasks) != 0 || plan.Result != 42 {
+		t.Errorf("Decompose() = %+v, want no tasks and result 42", plan)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"", "2+", "*2", "2 2", "2 a", "2+*2", "(1+2", "1+2)", "()", "2(3)",
+		"1.2.3", "1e", "1e+", ".", "1e400", "2^", "2///2", "%2",
+		"foo(1,)", "sqrt", "sqrt 4", "sqrt()", "sqrt(1, 2)", "min()", "max(1,)", "max(1 2)", "min(1", ",", "2,3",
+	} {
+		t.Run(expr, func(t *testing.T) {
+			_, err := Parse(expr)
+			if !errors.Is(err, ErrInvalidExpression) {
+				t.Errorf("Parse(%q) error = %v, want ErrInvalidExpression", expr, err)
+			}
+			var pe *ParseError
+			if !errors.As(err, &pe) {
+				t.Errorf("Parse(%q) error = %v, want *ParseError", expr, err)
+			}
+		})
+	}
+}
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		expr         string
+		message      string
+		offset       int
+		line, column int
+		expected     []string
+		snippet      string
+	}{
+		{"2+", "unexpected end of expression", 2, 1, 3, expectOperand, "2+\n  ^"},
+		{"1+2)", `unexpected ")"`, 3, 1, 4, []string{"operator", "end of expression"}, "1+2)\n   ^"},
+		{"(1+2", `unexpected end of expression, "(" at position 0 is not closed`, 4, 1, 5, []string{"operator", `")"`}, "(1+2\n    ^"},
+		{"sqrt 4", `unexpected "4" after function "sqrt"`, 5, 1, 6, []string{`"("`}, "sqrt 4\n     ^"},
+		{"max(1 2)", `unexpected "2" in call of "max"`, 6, 1, 7, []string{"operator", `","`, `")"`}, "max(1 2)\n      ^"},
+		{"sqrt(1, 2)", `function "sqrt" called with 2 arguments, expected 1`, 0, 1, 1, nil, "sqrt(1, 2)\n^"},
+		{"2 $ 3", `unexpected character '$'`, 2, 1, 3, nil, "2 $ 3\n  ^"},
+		{"1 +\n\t2 *", "unexpected end of expression", 8, 2, 5, expectOperand, "\t2 *\n\t   ^"},
+		{"π + ?", `unexpected character '?'`, 5, 1, 5, nil, "π + ?\n    ^"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := Parse(tt.expr)
+			var pe *ParseError
+			if !errors.As(err, &pe) {
+				t.Fatalf("Parse(%q) error = %v, want *ParseError", tt.expr, err)
+			}
+			if pe.Message != tt.message || pe.Offset != tt.offset || pe.Line != tt.line || pe.Column != tt.column {
+				t.Errorf("Parse(%q) = %q at %d (%d:%d), want %q at %d (%d:%d)",
+					tt.expr, pe.Message, pe.Offset, pe.Line, pe.Column, tt.message, tt.offset, tt.line, tt.column)
+			}
+			if !slices.Equal(pe.Expected, tt.expected) {
+				t.Errorf("Parse(%q) expected = %q, want %q", tt.expr, pe.Expected, tt.expected)
+			}
+			if got := pe.Snippet(); got != tt.snippet {
+				t.Errorf("Parse(%q) snippet = %q, want %q", tt.expr, got, tt.snippet)
+			}
+		})
+	}
+}
+
+// evalPlan выполняет задачи плана по порядку, подставляя результаты в зависящие задачи
+func evalPlan(t *testing.T, plan *Plan) float64 {
+	t.Helper()
+	if len(plan.Tasks) == 0 {
+		return plan.Result
+	}
+
+	results := map[string]float64{}
+	var last float64
+	for _, task := range plan.Tasks {
+		if task.Arg1TaskID != "" {
+			task.Arg1 = results[task.Arg1TaskID]
+		}
+		if task.Arg2TaskID != "" {
+			task.Arg2 = results[task.Arg2TaskID]
+		}
+		args := append([]float64(nil), task.Args...)
+		for i, id := range task.ArgTaskIDs {
+			if id != "" {
+				args[i] = results[id]
+			}
+		}
+		result, err := Calculate(context.Background(), &models.Task{
+			Arg1: task.Arg1, Arg2: task.Arg2, Args: args, Operation: task.Operation,
+		})
+		if err != nil {
+			t.Fatalf("Calculate(%+v) error = %v", task, err)
+		}
+		results[task.ID] = result
+		last = result
+	}
+	return last
+}
+
+func TestDecomposeGrammar(t *testing.T) {
+	tests := []struct {
+		expr  string
+		want  float64
+		tasks int
+	}{
+		{"-(3.5+1)*2", -9, 3},
+		{"2*(3+4)", 14, 2},
+		{" ( 1 + 2 ) * 3 ", 9, 2},
+		{"((2))", 2, 0},
+		{"1.5e-3*1000", 1.5, 1},
+		{"1E2+.5+1.", 101.5, 2},
+		{"-2*3", -6, 1},
+		{"2*-3", -6, 1},
+		{"2 - -3", 5, 1},
+		{"--2", 2, 0},
+		{"+5", 5, 0},
+		{"-(2)", -2, 0},
+		{"-(1-3)", 2, 2},
+		{"8/(4/2)", 4, 2},
+		{"2^3^2", 512, 2},
+		{"(2^3)^2", 64, 2},
+		{"-2^2", -4, 2},
+		{"2^-1", 0.5, 1},
+		{"2*3^2", 18, 2},
+		{"7%3+1", 2, 2},
+		{"7//2*2", 6, 2},
+		{"7 // 2 % 2", 1, 2},
+		{"sqrt(16)", 4, 1},
+		{"abs(-3) + 1", 4, 2},
+		{"min(3, 1+1, 5)", 2, 2},
+		{"max(1)", 1, 1},
+		{"max(2, sqrt(81), 3*2)", 9, 3},
+		{"-round(2.5)", -3, 2},
+		{"floor(-1.5) + ceil(1.2)", 0, 3},
+		{"2^log10(100)", 4, 2},
+		{"ln(exp(2))", 2, 2},
+		{"cos(0) + sin(0) + tan(0)", 1, 5},
+		{"min ( 4 , 2 )", 2, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			plan, err := Decompose(tt.expr)
+			if err != nil {
+				t.Fatalf("Decompose() error = %v", err)
+			}
+			if len(plan.Tasks) != tt.tasks {
+				t.Errorf("Decompose() produced %d tasks, want %d", len(plan.Tasks), tt.tasks)
+			}
+			if got := evalPlan(t, plan); got != tt.want {
+				t.Errorf("result = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
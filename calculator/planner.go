@@ -0,0 +1,217 @@
+package calculator
+
+import (
+	"math/big"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/m1tka051209/calculator-service/models"
+)
+
+// Plan результат разложения выражения на задачи
+type Plan struct {
+	// Tasks задачи в порядке зависимостей: задача идет после тех, чьи результаты ей нужны.
+	// Последняя задача корневая, ее результат и есть значение выражения.
+	Tasks []models.Task
+	// Result значение выражения, если оно не содержит операций и задач нет
+	Result float64
+	// ExactResult то же значение строкой, если план точный
+	ExactResult string
+	// Variables значения переменных и констант, подставленных в выражение, по имени
+	Variables map[string]float64
+	// Functions определения вызванных пользовательских функций по имени
+	Functions map[string]string
+	// Eliminated сколько задач убрали упрощения Options.Optimize
+	Eliminated int
+
+	// exact заполнять ли точные аргументы задач
+	exact bool
+	// built операнды уже разложенных узлов: подставленный в тело функции
+	// аргумент встречается в дереве несколько раз, но вычисляется одной задачей
+	built map[Node]operand
+}
+
+// Options настройки разложения выражения
+type Options struct {
+	// Precision режим точности: в точных режимах в задачи дополнительно
+	// записываются точные значения аргументов (ExactArg1, ExactArg2, ExactArgs).
+	// Scale и Rounding режима нужны только для свертки констант.
+	Precision string
+	Scale     int
+	Rounding  string
+	// Optimize упрощения выражения перед разложением
+	Optimize Optimizations
+	// Variables значения переменных, доступных выражению, по имени
+	Variables map[string]float64
+	// Functions пользовательские функции, доступные выражению, по имени
+	Functions map[string]models.UserFunction
+}
+
+// Decompose разбирает выражение и раскладывает его на зависимые задачи:
+// бинарные для операторов и задачи с произвольным числом аргументов для функций.
+// Доступны только встроенные константы.
+func Decompose(expr string) (*Plan, error) {
+	return DecomposeWith(expr, Options{})
+}
+
+// DecomposeExact как Decompose, но с точными значениями аргументов в задачах
+func DecomposeExact(expr string) (*Plan, error) {
+	return DecomposeWith(expr, Options{Precision: PrecisionRational})
+}
+
+// DecomposeWith как Decompose с настройками opts. Переменные подставляются
+// значениями, а вызовы пользовательских функций - их телами, так что задачи
+// состоят только из операторов и встроенных функций.
+func DecomposeWith(expr string, opts Options) (*Plan, error) {
+	root, err := Parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	r := newResolver(expr, opts)
+	if root, err = r.resolve(root, nil, nil); err != nil {
+		return nil, err
+	}
+
+	exact := IsExact(opts.Precision)
+	plan := &Plan{exact: exact, built: map[Node]operand{}}
+	if len(r.Variables) > 0 {
+		plan.Variables = r.Variables
+	}
+	if len(r.Functions) > 0 {
+		plan.Functions = r.Functions
+	}
+
+	// Сколько задач было бы без упрощений, считается тем же разложением
+	var unoptimized int
+	if opts.Optimize.enabled() {
+		full := &Plan{Variables: plan.Variables, built: map[Node]operand{}}
+		full.build(root)
+		unoptimized = len(full.Tasks)
+		root = newOptimizer(opts, plan.Variables).optimize(root)
+	}
+
+	arg := plan.build(root)
+	if opts.Optimize.enabled() {
+		plan.Eliminated = unoptimized - len(plan.Tasks)
+	}
+	if len(plan.Tasks) == 0 {
+		plan.Result = arg.value
+		if exact {
+			plan.ExactResult = formatRat(arg.exact)
+		}
+	}
+	return plan, nil
+}
+
+// operand аргумент задачи: либо готовое число, либо ссылка на задачу, которая его вычислит
+type operand struct {
+	value float64
+	// exact точное значение числа, если план точный
+	exact  *big.Rat
+	taskID string
+}
+
+// exactString точное значение операнда для записи в задачу
+func (o operand) exactString() string {
+	if o.exact == nil {
+		return ""
+	}
+	return formatRat(o.exact)
+}
+
+func (p *Plan) build(n Node) operand {
+	if arg, ok := p.built[n]; ok {
+		return arg
+	}
+	arg := p.buildNode(n)
+	p.built[n] = arg
+	return arg
+}
+
+func (p *Plan) buildNode(n Node) operand {
+	switch n := n.(type) {
+	case *NumberNode:
+		arg := operand{value: n.Value}
+		if p.exact {
+			// Литерал уже разобран как float64, big.Rat принимает тот же синтаксис
+			arg.exact, _ = new(big.Rat).SetString(n.Text)
+		}
+		return arg
+	case *VariableNode:
+		value := p.Variables[n.Name]
+		arg := operand{value: value}
+		if p.exact {
+			// Точное значение - кратчайшая десятичная запись: 0.13, а не двоичное приближение
+			arg.exact, _ = new(big.Rat).SetString(strconv.FormatFloat(value, 'g', -1, 64))
+		}
+		return arg
+	case *UnaryNode:
+		arg := p.build(n.Operand)
+		switch {
+		case n.Op == "+":
+			return arg
+		case arg.taskID == "":
+			// Знак числа учитывается сразу, отдельная задача не нужна
+			neg := operand{value: -arg.value}
+			if arg.exact != nil {
+				neg.exact = new(big.Rat).Neg(arg.exact)
+			}
+			return neg
+		default:
+			// -x вычисляется задачей 0 - x
+			return p.addTask("-", operand{value: 0, exact: new(big.Rat)}, arg)
+		}
+	case *BinaryNode:
+		return p.addTask(n.Op, p.build(n.Left), p.build(n.Right))
+	case *CallNode:
+		args := make([]operand, len(n.Args))
+		for i, arg := range n.Args {
+			args[i] = p.build(arg)
+		}
+		return p.addCall(n.Name, args)
+	default:
+		panic("calculator: unknown node type")
+	}
+}
+
+// addTask добавляет в план задачу op над аргументами и возвращает ссылку на ее результат
+func (p *Plan) addTask(op string, left, right operand) operand {
+	task := models.Task{
+		ID:         uuid.New().String(),
+		Arg1:       left.value,
+		Arg1TaskID: left.taskID,
+		Arg2:       right.value,
+		Arg2TaskID: right.taskID,
+		Operation:  op,
+		Status:     "pending",
+	}
+	if p.exact {
+		task.ExactArg1 = left.exactString()
+		task.ExactArg2 = right.exactString()
+	}
+	p.Tasks = append(p.Tasks, task)
+	return operand{taskID: task.ID}
+}
+
+// addCall добавляет в план задачу вызова функции name и возвращает ссылку на ее результат
+func (p *Plan) addCall(name string, args []operand) operand {
+	task := models.Task{
+		ID:         uuid.New().String(),
+		Args:       make([]float64, len(args)),
+		ArgTaskIDs: make([]string, len(args)),
+		Operation:  name,
+		Status:     "pending",
+	}
+	if p.exact {
+		task.ExactArgs = make([]string, len(args))
+	}
+	for i, arg := range args {
+		task.Args[i] = arg.value
+		task.ArgTaskIDs[i] = arg.taskID
+		if p.exact {
+			task.ExactArgs[i] = arg.exactString()
+		}
+	}
+	p.Tasks = append(p.Tasks, task)
+	return operand{taskID: task.ID}
+}
@@ -0,0 +1,189 @@
+package calculator
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/m1tka051209/calculator-service/models"
+)
+
+// MaxCallDepth наибольшая вложенность вызовов пользовательских функций друг в друга
+const MaxCallDepth = 16
+
+// maxInlinedNodes сколько узлов может породить подстановка тел пользовательских
+// функций; без предела несколько функций, дважды вызывающих предыдущую, дают
+// экспоненциально большое выражение
+const maxInlinedNodes = 10000
+
+// resolver подставляет в дерево выражения тела пользовательских функций на место
+// их вызовов и находит значения имен. В результате остаются только числа,
+// операторы, встроенные функции и имена с известными значениями.
+type resolver struct {
+	vars      map[string]float64
+	functions map[string]models.UserFunction
+
+	// Variables и Functions использованные значения имен и определения функций
+	Variables map[string]float64
+	Functions map[string]string
+
+	// bodies разобранные тела функций
+	bodies map[string]Node
+	// calls результаты подстановки по функции и аргументам: одинаковые вызовы
+	// с одними и теми же аргументами дают один узел и одну задачу в плане
+	calls map[string]Node
+	nodes int
+
+	// input исходное выражение; site позиция в нем вызова пользовательской функции,
+	// тело которой сейчас подставляется: ошибки в телах функций указывают на него
+	input string
+	site  int
+}
+
+func newResolver(input string, opts Options) *resolver {
+	return &resolver{
+		input:     input,
+		vars:      opts.Variables,
+		functions: opts.Functions,
+		Variables: map[string]float64{},
+		Functions: map[string]string{},
+		bodies:    map[string]Node{},
+		calls:     map[string]Node{},
+	}
+}
+
+// resolve возвращает дерево n с подставленными функциями. scope - аргументы
+// вызова, тело которого сейчас разбирается, по именам параметров; stack - цепочка
+// вызовов пользовательских функций до этого места. Ошибки возвращаются как *ParseError.
+func (r *resolver) resolve(n Node, scope map[string]Node, stack []string) (Node, error) {
+	r.nodes++
+	if r.nodes > maxInlinedNodes {
+		return nil, newParseError(r.input, r.site, nil,
+			"expression is too large after inlining functions (more than %d nodes)", maxInlinedNodes)
+	}
+
+	switch n := n.(type) {
+	case *NumberNode:
+		return n, nil
+	case *VariableNode:
+		// Аргументы уже разобраны в области вызывающего, повторно их не обходим
+		if arg, ok := scope[n.Name]; ok {
+			return arg, nil
+		}
+		value, ok := Constants[n.Name]
+		if !ok {
+			value, ok = r.vars[n.Name]
+		}
+		if !ok {
+			return nil, r.errorf(n.Pos, stack, "unknown variable %q", n.Name)
+		}
+		r.Variables[n.Name] = value
+		return n, nil
+	case *UnaryNode:
+		operand, err := r.resolve(n.Operand, scope, stack)
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryNode{Op: n.Op, Operand: operand}, nil
+	case *BinaryNode:
+		left, err := r.resolve(n.Left, scope, stack)
+		if err != nil {
+			return nil, err
+		}
+		right, err := r.resolve(n.Right, scope, stack)
+		if err != nil {
+			return nil, err
+		}
+		return &BinaryNode{Op: n.Op, Left: left, Right: right}, nil
+	case *CallNode:
+		args := make([]Node, len(n.Args))
+		for i, arg := range n.Args {
+			var err error
+			if args[i], err = r.resolve(arg, scope, stack); err != nil {
+				return nil, err
+			}
+		}
+		if _, ok := LookupFunction(n.Name); ok {
+			return &CallNode{Name: n.Name, Args: args, Pos: n.Pos}, nil
+		}
+		return r.inline(n, args, stack)
+	default:
+		panic("calculator: unknown node type")
+	}
+}
+
+// inline подставляет тело пользовательской функции вызова call с уже разобранными аргументами args
+func (r *resolver) inline(call *CallNode, args []Node, stack []string) (Node, error) {
+	fn, ok := r.functions[call.Name]
+	if !ok {
+		return nil, r.errorf(call.Pos, stack, "unknown function %q", call.Name)
+	}
+	if len(args) != len(fn.Params) {
+		return nil, r.errorf(call.Pos, stack, "function %q called with %d arguments, expected %d",
+			call.Name, len(args), len(fn.Params))
+	}
+	if slices.Contains(stack, call.Name) {
+		return nil, r.errorf(call.Pos, stack, "recursive call of function %q: %s -> %s",
+			call.Name, strings.Join(stack, " -> "), call.Name)
+	}
+	if len(stack) >= MaxCallDepth {
+		return nil, r.errorf(call.Pos, stack, "function calls are nested deeper than %d", MaxCallDepth)
+	}
+	if len(stack) == 0 {
+		r.site = call.Pos
+	}
+
+	var key strings.Builder
+	key.WriteString(call.Name)
+	for _, arg := range args {
+		fmt.Fprintf(&key, " %p", arg)
+	}
+	if n, ok := r.calls[key.String()]; ok {
+		return n, nil
+	}
+
+	body, err := r.body(&fn)
+	if err != nil {
+		return nil, err
+	}
+	scope := make(map[string]Node, len(args))
+	for i, param := range fn.Params {
+		scope[param] = args[i]
+	}
+	n, err := r.resolve(body, scope, append(stack[:len(stack):len(stack)], call.Name))
+	if err != nil {
+		return nil, err
+	}
+	r.calls[key.String()] = n
+	r.Functions[fn.Name] = fn.Definition()
+	return n, nil
+}
+
+// body возвращает разобранное тело функции
+func (r *resolver) body(fn *models.UserFunction) (Node, error) {
+	if body, ok := r.bodies[fn.Name]; ok {
+		return body, nil
+	}
+	body, err := Parse(fn.Body)
+	if err != nil {
+		// Позиция в теле функции не имеет смысла в выражении пользователя
+		var pe *ParseError
+		if errors.As(err, &pe) {
+			return nil, newParseError(r.input, r.site, nil, "%s (position %d in function %q)", pe.Message, pe.Offset, fn.Name)
+		}
+		return nil, fmt.Errorf("in function %q: %w", fn.Name, err)
+	}
+	r.bodies[fn.Name] = body
+	return body, nil
+}
+
+// errorf возвращает *ParseError на позиции pos выражения или, если stack не пуст,
+// на вызове функции в выражении с позицией pos в теле функции в сообщении
+func (r *resolver) errorf(pos int, stack []string, format string, args ...interface{}) error {
+	if len(stack) == 0 {
+		return newParseError(r.input, pos, nil, format, args...)
+	}
+	return newParseError(r.input, r.site, nil, "%s (position %d in function %q)",
+		fmt.Sprintf(format, args...), pos, stack[len(stack)-1])
+}
@@ -0,0 +1,46 @@
+package calculator
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrInvalidVariable возвращается для недопустимого имени или значения переменной
+var ErrInvalidVariable = errors.New("invalid variable")
+
+// Constants встроенные константы, доступные в любом выражении; переменные
+// пользователя не могут их переопределить
+var Constants = map[string]float64{
+	"pi": math.Pi,
+	"e":  math.E,
+}
+
+// ValidateVariable проверяет, что name можно использовать как имя переменной
+// пользователя, а value - как ее значение
+func ValidateVariable(name string, value float64) error {
+	if err := checkName(name); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidVariable, err)
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return fmt.Errorf("%w: value of %q must be a finite number", ErrInvalidVariable, name)
+	}
+	return nil
+}
+
+// checkName проверяет имя, которое пользователь дает переменной, функции или
+// параметру. Имя записывается как в выражении: буква или подчеркивание, затем
+// буквы, цифры и подчеркивания; имена встроенных функций и констант заняты.
+func checkName(name string) error {
+	tokens, err := tokenize(name)
+	if err != nil || len(tokens) != 2 || tokens[0].kind != tokenIdent {
+		return fmt.Errorf("name %q must start with a letter or underscore and contain only letters, digits and underscores", name)
+	}
+	if _, ok := LookupFunction(name); ok {
+		return fmt.Errorf("name %q is a built-in function", name)
+	}
+	if _, ok := Constants[name]; ok {
+		return fmt.Errorf("name %q is a built-in constant", name)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package calculator
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestDecomposeVariables(t *testing.T) {
+	vars := map[string]float64{"price": 100, "rate": 0.13, "unused": 1}
+
+	plan, err := DecomposeWith("price * (1 + rate)", Options{Variables: vars})
+	if err != nil {
+		t.Fatalf("DecomposeWith() error = %v", err)
+	}
+	if got := evalPlan(t, plan); math.Abs(got-113) > 1e-9 {
+		t.Errorf("result = %v, want 113", got)
+	}
+	if len(plan.Variables) != 2 || plan.Variables["price"] != 100 || plan.Variables["rate"] != 0.13 {
+		t.Errorf("Variables = %v, want only price and rate", plan.Variables)
+	}
+
+	plan, err = DecomposeWith("2*pi + e", Options{Variables: map[string]float64{"pi": 3}})
+	if err != nil {
+		t.Fatalf("DecomposeWith() error = %v", err)
+	}
+	if got := evalPlan(t, plan); got != 2*math.Pi+math.E {
+		t.Errorf("result = %v, want constants to win over variables", got)
+	}
+
+	plan, err = DecomposeWith("rate", Options{Precision: PrecisionDecimal, Variables: vars})
+	if err != nil {
+		t.Fatalf("DecomposeWith() error = %v", err)
+	}
+	if plan.ExactResult != "0.13" {
+		t.Errorf("ExactResult = %q, want 0.13", plan.ExactResult)
+	}
+
+	plan, err = Decompose("1 + 2")
+	if err != nil || plan.Variables != nil {
+		t.Errorf("Decompose() Variables = %v, error = %v, want none", plan.Variables, err)
+	}
+
+	for _, expr := range []string{"rate + 1", "max(1, x)", "-price"} {
+		if _, err := Decompose(expr); !errors.Is(err, ErrInvalidExpression) {
+			t.Errorf("Decompose(%q) error = %v, want ErrInvalidExpression", expr, err)
+		}
+	}
+}
+
+func TestValidateVariable(t *testing.T) {
+	for _, name := range []string{"rate", "_x", "x1", "ставка"} {
+		if err := ValidateVariable(name, 1); err != nil {
+			t.Errorf("ValidateVariable(%q) error = %v", name, err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		value float64
+	}{
+		{"", 1},
+		{"1x", 1},
+		{"a b", 1},
+		{"a+b", 1},
+		{"sqrt", 1},
+		{"pi", 1},
+		{"x", math.NaN()},
+		{"x", math.Inf(1)},
+	}
+	for _, tt := range tests {
+		if err := ValidateVariable(tt.name, tt.value); !errors.Is(err, ErrInvalidVariable) {
+			t.Errorf("ValidateVariable(%q, %v) error = %v, want ErrInvalidVariable", tt.name, tt.value, err)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os/signal"
+	"syscall"
+
+	"github.com/m1tka051209/calculator-service/config"
+	"github.com/m1tka051209/calculator-service/worker"
+)
+
+// Агент: пул воркеров, который получает задачи от оркестратора по gRPC.
+// Базу данных агент не использует, поэтому его можно запускать на других машинах.
+func main() {
+	cfg := config.Load()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if cfg.AgentToken == "" {
+		log.Fatal("AGENT_TOKEN is required to connect to the orchestrator")
+	}
+
+	log.Printf("Agent %s connecting to %s", cfg.AgentID, cfg.OrchestratorAddr)
+	err := worker.RunWorker(ctx, cfg.OrchestratorAddr, cfg.AgentID, cfg.AgentToken, cfg.WorkerPoolSize, cfg.HeartbeatInterval)
+	if err != nil {
+		log.Fatalf("Agent failed: %v", err)
+	}
+	log.Println("Agent stopped")
+}
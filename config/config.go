@@ -3,20 +3,109 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/m1tka051209/calculator-service/calculator"
 )
 
 type Config struct {
 	GRPCPort       string
 	DBPath         string
 	WorkerPoolSize int
+	// TaskLease срок, на который агент забирает задачу; агент продлевает его heartbeat'ами, пока считает
+	TaskLease time.Duration
+	// ReaperInterval как часто задачи с истекшей арендой возвращаются в очередь
+	ReaperInterval time.Duration
+	// TaskMaxAttempts сколько попыток дается задаче до отправки в dead letter
+	TaskMaxAttempts int
+	// RetryBaseDelay и RetryMaxDelay границы экспоненциальной задержки между попытками
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+	// AdminToken токен для /api/v1/admin; пустой отключает админские эндпоинты
+	AdminToken string
+	// JWTSecret ключ подписи токенов пользователей; без него шлюз не запускается
+	JWTSecret string
+	// OrchestratorAddr адрес gRPC оркестратора, к которому подключается агент
+	OrchestratorAddr string
+	// AgentID идентификатор агента; задачи в аренде закрепляются за ним
+	AgentID string
+	// AgentToken общий секрет оркестратора и агентов: без него gRPC вызовы отклоняются
+	AgentToken string
+	// HeartbeatInterval как часто агент продлевает аренду выполняемых задач
+	HeartbeatInterval time.Duration
+	// OperationTimes время выполнения каждой операции (TIME_*_MS), проставляется задачам при создании
+	OperationTimes map[string]time.Duration
+	// DecimalScale и DecimalRounding масштаб и округление режима decimal по умолчанию
+	DecimalScale    int
+	DecimalRounding string
+	// Optimizations упрощения выражений перед разложением на задачи (OPTIMIZATIONS):
+	// список fold, identities, short_circuit через запятую, all или none
+	Optimizations string
+	// WebhookSecret ключ HMAC-подписи отправляемых на callback URL выражений
+	WebhookSecret string
+	// WebhookMaxAttempts сколько раз пытаться доставить выражение на callback URL
+	WebhookMaxAttempts int
+	// WebhookRetryBaseDelay и WebhookRetryMaxDelay границы задержки между попытками доставки
+	WebhookRetryBaseDelay time.Duration
+	WebhookRetryMaxDelay  time.Duration
+	// WebhookPollInterval как часто проверяется очередь доставок
+	WebhookPollInterval time.Duration
 }
 
 func Load() *Config {
 	return &Config{
-		GRPCPort:       getEnv("GRPC_PORT", "50051"),
-		DBPath:         getEnv("DB_PATH", "data.db"),
-		WorkerPoolSize: getEnvAsInt("WORKER_POOL_SIZE", 3),
+		GRPCPort:              getEnv("GRPC_PORT", "50051"),
+		DBPath:                getEnv("DB_PATH", "data.db"),
+		WorkerPoolSize:        getEnvAsInt("WORKER_POOL_SIZE", 3),
+		TaskLease:             getEnvAsDuration("TASK_LEASE", 30*time.Second),
+		ReaperInterval:        getEnvAsDuration("REAPER_INTERVAL", 10*time.Second),
+		TaskMaxAttempts:       getEnvAsInt("TASK_MAX_ATTEMPTS", 3),
+		RetryBaseDelay:        getEnvAsDuration("RETRY_BASE_DELAY", time.Second),
+		RetryMaxDelay:         getEnvAsDuration("RETRY_MAX_DELAY", time.Minute),
+		AdminToken:            getEnv("ADMIN_TOKEN", ""),
+		JWTSecret:             getEnv("JWT_SECRET", ""),
+		OrchestratorAddr:      getEnv("ORCHESTRATOR_ADDR", "localhost:50051"),
+		AgentID:               getEnv("AGENT_ID", defaultAgentID()),
+		AgentToken:            getEnv("AGENT_TOKEN", ""),
+		HeartbeatInterval:     getEnvAsDuration("HEARTBEAT_INTERVAL", 5*time.Second),
+		OperationTimes:        loadOperationTimes(),
+		DecimalScale:          getEnvAsInt("DECIMAL_SCALE", 10),
+		DecimalRounding:       getEnv("DECIMAL_ROUNDING", calculator.RoundHalfEven),
+		Optimizations:         getEnv("OPTIMIZATIONS", "none"),
+		WebhookSecret:         getEnv("WEBHOOK_SECRET", ""),
+		WebhookMaxAttempts:    getEnvAsInt("WEBHOOK_MAX_ATTEMPTS", 5),
+		WebhookRetryBaseDelay: getEnvAsDuration("WEBHOOK_RETRY_BASE_DELAY", time.Second),
+		WebhookRetryMaxDelay:  getEnvAsDuration("WEBHOOK_RETRY_MAX_DELAY", 5*time.Minute),
+		WebhookPollInterval:   getEnvAsDuration("WEBHOOK_POLL_INTERVAL", time.Second),
+	}
+}
+
+// loadOperationTimes читает время операций; у функций оно задается
+// переменными TIME_FUNCTION_<ИМЯ>_MS, например TIME_FUNCTION_SQRT_MS
+func loadOperationTimes() map[string]time.Duration {
+	times := map[string]time.Duration{
+		"+":  getEnvAsMillis("TIME_ADDITION_MS", 0),
+		"-":  getEnvAsMillis("TIME_SUBTRACTION_MS", 0),
+		"*":  getEnvAsMillis("TIME_MULTIPLICATIONS_MS", 0),
+		"/":  getEnvAsMillis("TIME_DIVISIONS_MS", 0),
+		"//": getEnvAsMillis("TIME_INTEGER_DIVISIONS_MS", 0),
+		"%":  getEnvAsMillis("TIME_MODULO_MS", 0),
+		"^":  getEnvAsMillis("TIME_EXPONENTIATIONS_MS", 0),
+	}
+	for _, name := range calculator.FunctionNames() {
+		times[name] = getEnvAsMillis("TIME_FUNCTION_"+strings.ToUpper(name)+"_MS", 0)
 	}
+	return times
+}
+
+// defaultAgentID строит идентификатор агента из имени хоста и PID процесса
+func defaultAgentID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "agent"
+	}
+	return host + "-" + strconv.Itoa(os.Getpid())
 }
 
 func getEnv(key, defaultValue string) string {
@@ -33,4 +122,22 @@ func getEnvAsInt(key string, defaultValue int) int {
 		}
 	}
 	return defaultValue
-}
\ No newline at end of file
+}
+
+func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
+	if value, exists := os.LookupEnv(key); exists {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			return d
+		}
+	}
+	return defaultValue
+}
+
+// getEnvAsMillis читает длительность в миллисекундах; отрицательные значения игнорируются
+func getEnvAsMillis(key string, defaultValue int) time.Duration {
+	ms := getEnvAsInt(key, defaultValue)
+	if ms < 0 {
+		ms = defaultValue
+	}
+	return time.Duration(ms) * time.Millisecond
+}
@@ -0,0 +1,127 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/m1tka051209/calculator-service/models"
+)
+
+// Аргументы задач-вызовов функций хранятся в колонках args и arg_task_ids как
+// JSON-массивы одинаковой длины, точные значения в точных режимах - в exact_args;
+// у задач бинарных операций там NULL.
+
+// encodeArgs готовит Args и ArgTaskIDs задачи к записи
+func encodeArgs(t *models.Task) (args, argTaskIDs sql.NullString, err error) {
+	if t.Args == nil {
+		return args, argTaskIDs, nil
+	}
+	data, err := json.Marshal(t.Args)
+	if err != nil {
+		return args, argTaskIDs, err
+	}
+	args = sql.NullString{String: string(data), Valid: true}
+
+	ids := t.ArgTaskIDs
+	if ids == nil {
+		ids = make([]string, len(t.Args))
+	}
+	data, err = json.Marshal(ids)
+	if err != nil {
+		return args, argTaskIDs, err
+	}
+	argTaskIDs = sql.NullString{String: string(data), Valid: true}
+	return args, argTaskIDs, nil
+}
+
+// encodeExactArgs готовит ExactArgs задачи к записи
+func encodeExactArgs(t *models.Task) (sql.NullString, error) {
+	if t.ExactArgs == nil {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(t.ExactArgs)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+// decodeArgs заполняет Args и ArgTaskIDs задачи из прочитанных колонок
+func decodeArgs(t *models.Task, args, argTaskIDs sql.NullString) error {
+	if args.Valid {
+		if err := json.Unmarshal([]byte(args.String), &t.Args); err != nil {
+			return err
+		}
+	}
+	if argTaskIDs.Valid {
+		if err := json.Unmarshal([]byte(argTaskIDs.String), &t.ArgTaskIDs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// substituteCallArgs подставляет результат задачи taskID (и точный результат
+// exact, если он есть) в аргументы вызовов функций, которые от нее зависят.
+// Возвращает число обновленных задач.
+func substituteCallArgs(ctx context.Context, tx *sql.Tx, taskID string, result float64, exact string) (int64, error) {
+	rows, err := tx.QueryContext(ctx,
+		`SELECT DISTINCT t.id, t.args, t.arg_task_ids, t.exact_args
+		 FROM tasks t, json_each(t.arg_task_ids) a
+		 WHERE a.value = ?`, taskID)
+	if err != nil {
+		return 0, err
+	}
+
+	var dependents []models.Task
+	for rows.Next() {
+		var t models.Task
+		var args, argTaskIDs, exactArgs sql.NullString
+		if err := rows.Scan(&t.ID, &args, &argTaskIDs, &exactArgs); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		err := decodeArgs(&t, args, argTaskIDs)
+		if err == nil && exactArgs.Valid {
+			err = json.Unmarshal([]byte(exactArgs.String), &t.ExactArgs)
+		}
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		dependents = append(dependents, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for i := range dependents {
+		t := &dependents[i]
+		for j, id := range t.ArgTaskIDs {
+			if id != taskID {
+				continue
+			}
+			if j < len(t.Args) {
+				t.Args[j] = result
+			}
+			if j < len(t.ExactArgs) {
+				t.ExactArgs[j] = exact
+			}
+		}
+		args, _, err := encodeArgs(t)
+		if err != nil {
+			return 0, err
+		}
+		exactArgs, err := encodeExactArgs(t)
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE tasks SET args = ?, exact_args = ? WHERE id = ?", args, exactArgs, t.ID)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(dependents)), nil
+}
@@ -0,0 +1,89 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/m1tka051209/calculator-service/models"
+)
+
+// Параметры функции хранятся в колонке params как JSON-массив имен
+
+// SetFunction создает функцию пользователя или заменяет определение существующей
+func (r *SQLiteRepository) SetFunction(ctx context.Context, fn *models.UserFunction) error {
+	params, err := json.Marshal(fn.Params)
+	if err != nil {
+		return err
+	}
+	return r.db.QueryRowContext(ctx,
+		`INSERT INTO functions(user_id, name, params, body) VALUES(?, ?, ?, ?)
+		 ON CONFLICT(user_id, name) DO UPDATE SET
+			params = excluded.params,
+			body = excluded.body,
+			updated_at = CURRENT_TIMESTAMP
+		 RETURNING updated_at`,
+		fn.UserID, fn.Name, string(params), fn.Body).Scan(&fn.UpdatedAt)
+}
+
+// GetFunction возвращает функцию пользователя по имени или ErrFunctionNotFound
+func (r *SQLiteRepository) GetFunction(ctx context.Context, userID, name string) (*models.UserFunction, error) {
+	row := r.db.QueryRowContext(ctx,
+		"SELECT name, params, body, updated_at FROM functions WHERE user_id = ? AND name = ?", userID, name)
+	fn, err := scanFunction(row, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrFunctionNotFound
+	}
+	return fn, err
+}
+
+// GetFunctions возвращает функции пользователя в порядке имен
+func (r *SQLiteRepository) GetFunctions(ctx context.Context, userID string) ([]models.UserFunction, error) {
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT name, params, body, updated_at FROM functions WHERE user_id = ? ORDER BY name", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fns []models.UserFunction
+	for rows.Next() {
+		fn, err := scanFunction(rows, userID)
+		if err != nil {
+			return nil, err
+		}
+		fns = append(fns, *fn)
+	}
+	return fns, rows.Err()
+}
+
+// DeleteFunction удаляет функцию пользователя; отсутствующая - ErrFunctionNotFound.
+// Выражения, уже разложенные на задачи, от удаления не зависят.
+func (r *SQLiteRepository) DeleteFunction(ctx context.Context, userID, name string) error {
+	res, err := r.db.ExecContext(ctx, "DELETE FROM functions WHERE user_id = ? AND name = ?", userID, name)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrFunctionNotFound
+	}
+	return nil
+}
+
+// scanFunction читает строку с колонками name, params, body, updated_at
+func scanFunction(row interface{ Scan(...any) error }, userID string) (*models.UserFunction, error) {
+	fn := models.UserFunction{UserID: userID}
+	var params string
+	if err := row.Scan(&fn.Name, &params, &fn.Body, &fn.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(params), &fn.Params); err != nil {
+		return nil, err
+	}
+	return &fn, nil
+}
@@ -3,21 +3,74 @@ package db
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/m1tka051209/calculator-service/models"
-	_ "modernc.org/sqlite"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
 )
 
+// ErrLeaseLost возвращается, если задача больше не закреплена за воркером
+// (аренда истекла и задача возвращена в очередь или уже завершена)
+var ErrLeaseLost = errors.New("task lease lost")
+
+// ErrTaskNotFound возвращается, если задачи нет или она не в ожидаемом статусе
+var ErrTaskNotFound = errors.New("task not found")
+
+// ErrRequeueBlocked возвращается, если выражение задачи из dead letter уже не
+// завершится: в нем есть задача, упавшая с окончательной ошибкой
+var ErrRequeueBlocked = errors.New("expression has permanently failed tasks")
+
+// ErrExpressionNotFound возвращается, если выражения с таким ID нет
+var ErrExpressionNotFound = errors.New("expression not found")
+
+// ErrUserExists возвращается при регистрации уже занятого логина
+var ErrUserExists = errors.New("user already exists")
+
+// ErrUserNotFound возвращается, если пользователя с таким логином нет
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrVariableNotFound возвращается, если у пользователя нет переменной с таким именем
+var ErrVariableNotFound = errors.New("variable not found")
+
+// ErrFunctionNotFound возвращается, если у пользователя нет функции с таким именем
+var ErrFunctionNotFound = errors.New("function not found")
+
 type Repository interface {
 	CreateUser(ctx context.Context, login, passwordHash string) error
 	GetUserByLogin(ctx context.Context, login string) (*models.User, error)
-	CreateExpression(ctx context.Context, userID, expr string) (string, error)
+	CreateExpression(ctx context.Context, expr *models.Expression, tasks []models.Task) (string, error)
+	GetExpression(ctx context.Context, id string) (*models.Expression, error)
 	GetExpressionsByUser(ctx context.Context, userID string) ([]models.Expression, error)
+	GetExpressionTasks(ctx context.Context, expressionID string) ([]models.Task, error)
 	GetPendingTasks(ctx context.Context, limit int) ([]models.Task, error)
-	UpdateTaskResult(ctx context.Context, taskID string, result float64) error
-	UpdateTaskStatus(ctx context.Context, taskID, status string) error
+	GetTask(ctx context.Context, taskID string) (*models.Task, error)
+	ClaimTask(ctx context.Context, workerID string, lease time.Duration) (*models.Task, error)
+	RenewLease(ctx context.Context, taskID, workerID string, lease time.Duration) error
+	ReclaimExpiredTasks(ctx context.Context, maxAttempts int) (reclaimed int64, dead []string, err error)
+	RetryTask(ctx context.Context, taskID, workerID, lastError string, nextAttemptAt time.Time) error
+	DeadLetterTask(ctx context.Context, taskID, workerID, lastError string) error
+	FailTask(ctx context.Context, taskID, workerID, lastError string) error
+	GetDeadTasks(ctx context.Context) ([]models.Task, error)
+	RequeueDeadTask(ctx context.Context, taskID string) (requeued []string, err error)
+	UpdateTaskResult(ctx context.Context, taskID, workerID string, result float64) error
+	UpdateTaskExactResult(ctx context.Context, taskID, workerID string, result float64, exact, decimal string) error
+	GetDueWebhooks(ctx context.Context, limit int) ([]models.WebhookDelivery, error)
+	RecordWebhookAttempt(ctx context.Context, attempt *models.WebhookAttempt, status string, nextAttemptAt *time.Time) error
+	GetWebhookAttempts(ctx context.Context, expressionID string) ([]models.WebhookAttempt, error)
+	SetVariable(ctx context.Context, v *models.Variable) error
+	GetVariable(ctx context.Context, userID, name string) (*models.Variable, error)
+	GetVariables(ctx context.Context, userID string) ([]models.Variable, error)
+	DeleteVariable(ctx context.Context, userID, name string) error
+	SetFunction(ctx context.Context, fn *models.UserFunction) error
+	GetFunction(ctx context.Context, userID, name string) (*models.UserFunction, error)
+	GetFunctions(ctx context.Context, userID string) ([]models.UserFunction, error)
+	DeleteFunction(ctx context.Context, userID, name string) error
 	Close() error
 }
 
@@ -26,7 +79,11 @@ type SQLiteRepository struct {
 }
 
 func NewSQLiteRepository(dbPath string) (*SQLiteRepository, error) {
-	db, err := sql.Open("sqlite", dbPath+"?_journal_mode=WAL&_timeout=5000&_busy_timeout=5000")
+	// _txlock=immediate: транзакции сразу берут блокировку на запись, чтобы
+	// конкурирующие воркеры ждали busy_timeout, а не получали SQLITE_BUSY.
+	// _time_format=sqlite: время пишется в формате, понятном julianday().
+	db, err := sql.Open("sqlite", dbPath+
+		"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_txlock=immediate&_time_format=sqlite")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -35,6 +92,10 @@ func NewSQLiteRepository(dbPath string) (*SQLiteRepository, error) {
 		return nil, fmt.Errorf("failed to create tables: %w", err)
 	}
 
+	if err := migrateColumns(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate tables: %w", err)
+	}
+
 	return &SQLiteRepository{db: db}, nil
 }
 
@@ -52,6 +113,16 @@ func createTables(db *sql.DB) error {
 			expression TEXT NOT NULL,
 			status TEXT NOT NULL,
 			result REAL,
+			error TEXT,
+			callback_url TEXT,
+			precision TEXT,
+			scale INTEGER,
+			rounding TEXT,
+			exact_result TEXT,
+			decimal_result TEXT,
+			variables TEXT,
+			functions TEXT,
+			eliminated_tasks INTEGER NOT NULL DEFAULT 0,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			started_at TIMESTAMP,
 			completed_at TIMESTAMP,
@@ -63,22 +134,137 @@ func createTables(db *sql.DB) error {
 			expression_id TEXT NOT NULL,
 			arg1 REAL NOT NULL,
 			arg2 REAL NOT NULL,
+			arg1_task_id TEXT REFERENCES tasks(id),
+			arg2_task_id TEXT REFERENCES tasks(id),
+			args TEXT,
+			arg_task_ids TEXT,
 			operation TEXT NOT NULL,
 			operation_time INTEGER NOT NULL,
 			status TEXT NOT NULL DEFAULT 'pending',
 			result REAL,
+			worker_id TEXT,
+			lease_expires_at TIMESTAMP,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT,
+			next_attempt_at TIMESTAMP,
 			started_at TIMESTAMP,
 			completed_at TIMESTAMP,
+			precision TEXT,
+			scale INTEGER,
+			rounding TEXT,
+			exact_arg1 TEXT,
+			exact_arg2 TEXT,
+			exact_args TEXT,
+			exact_result TEXT,
 			FOREIGN KEY(expression_id) REFERENCES expressions(id)
 		);
+
+		CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id TEXT PRIMARY KEY,
+			expression_id TEXT UNIQUE NOT NULL,
+			url TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT,
+			next_attempt_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			delivered_at TIMESTAMP,
+			FOREIGN KEY(expression_id) REFERENCES expressions(id)
+		);
+
+		CREATE TABLE IF NOT EXISTS webhook_attempts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			delivery_id TEXT NOT NULL,
+			attempt INTEGER NOT NULL,
+			status_code INTEGER,
+			error TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY(delivery_id) REFERENCES webhook_deliveries(id)
+		);
+
+		CREATE TABLE IF NOT EXISTS variables (
+			user_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			value REAL NOT NULL,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY(user_id, name),
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		);
+
+		CREATE TABLE IF NOT EXISTS functions (
+			user_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			params TEXT NOT NULL,
+			body TEXT NOT NULL,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY(user_id, name),
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		);
 	`)
 	return err
 }
 
+// addedColumns колонки, появившиеся после первой версии схемы.
+// createTables создает их в новых базах, migrateColumns досоздает в старых.
+var addedColumns = []struct {
+	table, column, definition string
+}{
+	{"expressions", "error", "TEXT"},
+	{"expressions", "callback_url", "TEXT"},
+	{"expressions", "precision", "TEXT"},
+	{"expressions", "scale", "INTEGER"},
+	{"expressions", "rounding", "TEXT"},
+	{"expressions", "exact_result", "TEXT"},
+	{"expressions", "decimal_result", "TEXT"},
+	{"expressions", "variables", "TEXT"},
+	{"expressions", "functions", "TEXT"},
+	{"expressions", "eliminated_tasks", "INTEGER NOT NULL DEFAULT 0"},
+	{"tasks", "arg1_task_id", "TEXT REFERENCES tasks(id)"},
+	{"tasks", "arg2_task_id", "TEXT REFERENCES tasks(id)"},
+	{"tasks", "args", "TEXT"},
+	{"tasks", "arg_task_ids", "TEXT"},
+	{"tasks", "worker_id", "TEXT"},
+	{"tasks", "lease_expires_at", "TIMESTAMP"},
+	{"tasks", "attempts", "INTEGER NOT NULL DEFAULT 0"},
+	{"tasks", "last_error", "TEXT"},
+	{"tasks", "next_attempt_at", "TIMESTAMP"},
+	{"tasks", "precision", "TEXT"},
+	{"tasks", "scale", "INTEGER"},
+	{"tasks", "rounding", "TEXT"},
+	{"tasks", "exact_arg1", "TEXT"},
+	{"tasks", "exact_arg2", "TEXT"},
+	{"tasks", "exact_args", "TEXT"},
+	{"tasks", "exact_result", "TEXT"},
+}
+
+func migrateColumns(db *sql.DB) error {
+	for _, c := range addedColumns {
+		var exists bool
+		err := db.QueryRow(
+			"SELECT COUNT(*) > 0 FROM pragma_table_info(?) WHERE name = ?", c.table, c.column).
+			Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.column, c.definition)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateUser сохраняет пользователя; занятый логин возвращается как ErrUserExists
 func (r *SQLiteRepository) CreateUser(ctx context.Context, login, passwordHash string) error {
 	_, err := r.db.ExecContext(ctx,
 		"INSERT INTO users(id, login, password_hash) VALUES(?, ?, ?)",
 		uuid.New().String(), login, passwordHash)
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+		return ErrUserExists
+	}
 	return err
 }
 
@@ -87,59 +273,191 @@ func (r *SQLiteRepository) GetUserByLogin(ctx context.Context, login string) (*m
 	err := r.db.QueryRowContext(ctx,
 		"SELECT id, login, password_hash FROM users WHERE login = ?", login).
 		Scan(&user.ID, &user.Login, &user.PasswordHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
-func (r *SQLiteRepository) CreateExpression(ctx context.Context, userID, expr string) (string, error) {
-	id := uuid.New().String()
-	_, err := r.db.ExecContext(ctx,
-		"INSERT INTO expressions(id, user_id, expression, status) VALUES(?, ?, ?, 'pending')",
-		id, userID, expr)
-	return id, err
+// CreateExpression сохраняет выражение вместе с его задачами в одной транзакции.
+// Если задач нет, выражение сохраняется с уже известными статусом и результатом.
+func (r *SQLiteRepository) CreateExpression(ctx context.Context, expr *models.Expression, tasks []models.Task) (string, error) {
+	if expr.ID == "" {
+		expr.ID = uuid.New().String()
+	}
+	if expr.Status == "" {
+		expr.Status = "pending"
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var result sql.NullFloat64
+	var completedAt sql.NullTime
+	if expr.Status == "completed" && expr.Result != nil {
+		now := time.Now().UTC()
+		expr.CompletedAt = &now
+		result = sql.NullFloat64{Float64: *expr.Result, Valid: true}
+		completedAt = sql.NullTime{Time: now, Valid: true}
+	}
+	var scale sql.NullInt64
+	if expr.Scale != nil {
+		scale = sql.NullInt64{Int64: int64(*expr.Scale), Valid: true}
+	}
+	variables, err := encodeJSON(expr.Variables)
+	if err != nil {
+		return "", err
+	}
+	functions, err := encodeJSON(expr.Functions)
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO expressions(id, user_id, expression, status, result, completed_at, callback_url,
+			precision, scale, rounding, exact_result, decimal_result, variables, functions, eliminated_tasks)
+		 VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		expr.ID, expr.UserID, expr.Expression, expr.Status, result, completedAt, nullString(expr.CallbackURL),
+		nullString(expr.Precision), scale, nullString(expr.Rounding), nullString(expr.ExactResult),
+		nullString(expr.DecimalResult), variables, functions, expr.EliminatedTasks)
+	if err != nil {
+		return "", err
+	}
+	if err := enqueueWebhook(ctx, tx, expr.ID); err != nil {
+		return "", err
+	}
+
+	for i := range tasks {
+		t := &tasks[i]
+		t.ExpressionID = expr.ID
+		if t.Status == "" {
+			t.Status = "pending"
+		}
+		args, argTaskIDs, err := encodeArgs(t)
+		if err != nil {
+			return "", err
+		}
+		exactArgs, err := encodeExactArgs(t)
+		if err != nil {
+			return "", err
+		}
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO tasks(id, expression_id, arg1, arg2, arg1_task_id, arg2_task_id, args, arg_task_ids,
+				operation, operation_time, status, precision, scale, rounding, exact_arg1, exact_arg2, exact_args)
+			 VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			t.ID, t.ExpressionID, t.Arg1, t.Arg2, nullString(t.Arg1TaskID), nullString(t.Arg2TaskID),
+			args, argTaskIDs, t.Operation, t.OperationTime, t.Status,
+			nullString(t.Precision), t.Scale, nullString(t.Rounding),
+			nullString(t.ExactArg1), nullString(t.ExactArg2), exactArgs)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return expr.ID, nil
+}
+
+// expressionColumns колонки выражения в порядке, который ожидает scanExpression
+const expressionColumns = `id, user_id, expression, status, result, error, callback_url,
+	created_at, started_at, completed_at, precision, scale, rounding, exact_result, decimal_result, variables,
+	functions, eliminated_tasks`
+
+// scanExpression читает строку с колонками expressionColumns
+func scanExpression(row interface{ Scan(...any) error }) (*models.Expression, error) {
+	var e models.Expression
+	var result sql.NullFloat64
+	var exprErr, callbackURL, precision, rounding, exactResult, decimalResult, variables, functions sql.NullString
+	var scale sql.NullInt64
+	var startedAt, completedAt sql.NullTime
+	err := row.Scan(&e.ID, &e.UserID, &e.Expression, &e.Status, &result, &exprErr, &callbackURL,
+		&e.CreatedAt, &startedAt, &completedAt, &precision, &scale, &rounding, &exactResult, &decimalResult,
+		&variables, &functions, &e.EliminatedTasks)
+	if err != nil {
+		return nil, err
+	}
+
+	e.Result = nullFloatPtr(result)
+	e.Error = exprErr.String
+	e.CallbackURL = callbackURL.String
+	e.StartedAt = nullTimePtr(startedAt)
+	e.CompletedAt = nullTimePtr(completedAt)
+	e.Precision = precision.String
+	if scale.Valid {
+		s := int(scale.Int64)
+		e.Scale = &s
+	}
+	e.Rounding = rounding.String
+	e.ExactResult = exactResult.String
+	e.DecimalResult = decimalResult.String
+	if err := decodeJSON(variables, &e.Variables); err != nil {
+		return nil, err
+	}
+	if err := decodeJSON(functions, &e.Functions); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// GetExpression возвращает выражение по ID или ErrExpressionNotFound
+func (r *SQLiteRepository) GetExpression(ctx context.Context, id string) (*models.Expression, error) {
+	e, err := scanExpression(r.db.QueryRowContext(ctx,
+		`SELECT `+expressionColumns+` FROM expressions WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrExpressionNotFound
+	}
+	return e, err
 }
 
 func (r *SQLiteRepository) GetExpressionsByUser(ctx context.Context, userID string) ([]models.Expression, error) {
-    rows, err := r.db.QueryContext(ctx,
-        `SELECT id, expression, status, result, created_at, started_at, completed_at 
-         FROM expressions WHERE user_id = ? ORDER BY created_at DESC`, userID)
-    if err != nil {
-        return nil, err
-    }
-    defer rows.Close()
-
-    var exprs []models.Expression
-    for rows.Next() {
-        var e models.Expression
-        var startedAt, completedAt sql.NullTime
-        
-        err := rows.Scan(
-            &e.ID,
-            &e.Expression,
-            &e.Status,
-            &e.Result,
-            &e.CreatedAt,
-            &startedAt,
-            &completedAt,
-        )
-        if err != nil {
-            return nil, err
-        }
-
-        if startedAt.Valid {
-            e.StartedAt = &startedAt.Time
-        }
-        if completedAt.Valid {
-            e.CompletedAt = &completedAt.Time
-        }
-
-        exprs = append(exprs, e)
-    }
-    return exprs, nil
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+expressionColumns+` FROM expressions WHERE user_id = ? ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var exprs []models.Expression
+	for rows.Next() {
+		e, err := scanExpression(rows)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, *e)
+	}
+	return exprs, rows.Err()
 }
 
+// GetExpressionTasks возвращает все задачи выражения в порядке создания,
+// то есть каждая задача идет после задач, вычисляющих ее аргументы
+func (r *SQLiteRepository) GetExpressionTasks(ctx context.Context, expressionID string) ([]models.Task, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+taskColumns+` FROM tasks WHERE expression_id = ? ORDER BY rowid`, expressionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []models.Task
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, *t)
+	}
+	return tasks, rows.Err()
+}
+
+// GetPendingTasks возвращает задачи, готовые к выполнению: ожидающие и
+// с уже вычисленными аргументами, если те зависят от других задач
 func (r *SQLiteRepository) GetPendingTasks(ctx context.Context, limit int) ([]models.Task, error) {
 	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -148,8 +466,7 @@ func (r *SQLiteRepository) GetPendingTasks(ctx context.Context, limit int) ([]mo
 	defer tx.Rollback()
 
 	rows, err := tx.QueryContext(ctx,
-		`SELECT id, expression_id, arg1, arg2, operation, operation_time 
-		 FROM tasks WHERE status = 'pending' LIMIT ?`, limit)
+		`SELECT `+taskColumns+` FROM tasks t WHERE `+readyCondition+` LIMIT ?`, time.Now().UTC(), limit)
 	if err != nil {
 		return nil, err
 	}
@@ -157,12 +474,11 @@ func (r *SQLiteRepository) GetPendingTasks(ctx context.Context, limit int) ([]mo
 
 	var tasks []models.Task
 	for rows.Next() {
-		var t models.Task
-		err := rows.Scan(&t.ID, &t.ExpressionID, &t.Arg1, &t.Arg2, &t.Operation, &t.OperationTime)
+		t, err := scanTask(rows)
 		if err != nil {
 			return nil, err
 		}
-		tasks = append(tasks, t)
+		tasks = append(tasks, *t)
 	}
 
 	if err := tx.Commit(); err != nil {
@@ -172,35 +488,526 @@ func (r *SQLiteRepository) GetPendingTasks(ctx context.Context, limit int) ([]mo
 	return tasks, nil
 }
 
-func (r *SQLiteRepository) UpdateTaskResult(ctx context.Context, taskID string, result float64) error {
-	_, err := r.db.ExecContext(ctx,
+// GetTask возвращает задачу по ID или ErrTaskNotFound
+func (r *SQLiteRepository) GetTask(ctx context.Context, taskID string) (*models.Task, error) {
+	t, err := scanTask(r.db.QueryRowContext(ctx, `SELECT `+taskColumns+` FROM tasks WHERE id = ?`, taskID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTaskNotFound
+	}
+	return t, err
+}
+
+// taskColumns колонки задачи в порядке, который ожидает scanTask
+const taskColumns = `id, expression_id, arg1, arg2, arg1_task_id, arg2_task_id, args, arg_task_ids,
+	operation, operation_time, status, result, worker_id, attempts, last_error,
+	next_attempt_at, lease_expires_at, started_at, completed_at,
+	precision, scale, rounding, exact_arg1, exact_arg2, exact_args, exact_result`
+
+// scanTask читает строку с колонками taskColumns
+func scanTask(row interface{ Scan(...any) error }) (*models.Task, error) {
+	var t models.Task
+	var arg1TaskID, arg2TaskID, args, argTaskIDs, workerID, lastError sql.NullString
+	var precision, rounding, exactArg1, exactArg2, exactArgs, exactResult sql.NullString
+	var result sql.NullFloat64
+	var scale sql.NullInt64
+	var nextAttemptAt, leaseExpiresAt, startedAt, completedAt sql.NullTime
+	err := row.Scan(&t.ID, &t.ExpressionID, &t.Arg1, &t.Arg2, &arg1TaskID, &arg2TaskID, &args, &argTaskIDs,
+		&t.Operation, &t.OperationTime, &t.Status, &result, &workerID, &t.Attempts, &lastError,
+		&nextAttemptAt, &leaseExpiresAt, &startedAt, &completedAt,
+		&precision, &scale, &rounding, &exactArg1, &exactArg2, &exactArgs, &exactResult)
+	if err != nil {
+		return nil, err
+	}
+
+	t.Arg1TaskID = arg1TaskID.String
+	t.Arg2TaskID = arg2TaskID.String
+	if err := decodeArgs(&t, args, argTaskIDs); err != nil {
+		return nil, err
+	}
+	t.Result = nullFloatPtr(result)
+	t.WorkerID = workerID.String
+	t.LastError = lastError.String
+	t.NextAttemptAt = nullTimePtr(nextAttemptAt)
+	t.LeaseExpiresAt = nullTimePtr(leaseExpiresAt)
+	t.StartedAt = nullTimePtr(startedAt)
+	t.CompletedAt = nullTimePtr(completedAt)
+	t.Precision = precision.String
+	t.Scale = int(scale.Int64)
+	t.Rounding = rounding.String
+	t.ExactArg1 = exactArg1.String
+	t.ExactArg2 = exactArg2.String
+	t.ExactResult = exactResult.String
+	if exactArgs.Valid {
+		if err := json.Unmarshal([]byte(exactArgs.String), &t.ExactArgs); err != nil {
+			return nil, err
+		}
+	}
+	return &t, nil
+}
+
+// readyCondition условие готовности задачи t: она ожидает выполнения, время
+// повторной попытки (параметр - текущее время) наступило, а задачи,
+// вычисляющие ее аргументы, уже завершены
+const readyCondition = `t.status = 'pending'
+	AND (t.next_attempt_at IS NULL OR julianday(t.next_attempt_at) <= julianday(?))
+	AND NOT EXISTS (
+		SELECT 1 FROM tasks d
+		WHERE d.id IN (t.arg1_task_id, t.arg2_task_id) AND d.status != 'completed')
+	AND NOT EXISTS (
+		SELECT 1 FROM json_each(t.arg_task_ids) a JOIN tasks d ON d.id = a.value
+		WHERE d.status != 'completed')`
+
+// ClaimTask атомарно забирает одну готовую задачу: статус, воркер и срок аренды
+// выставляются одним UPDATE, поэтому одну задачу не получат два воркера.
+// Возвращает nil, если готовых задач нет.
+func (r *SQLiteRepository) ClaimTask(ctx context.Context, workerID string, lease time.Duration) (*models.Task, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	t, err := scanTask(tx.QueryRowContext(ctx,
+		`UPDATE tasks SET
+			status = 'processing',
+			worker_id = ?,
+			lease_expires_at = ?,
+			attempts = attempts + 1,
+			started_at = CURRENT_TIMESTAMP
+		 WHERE id = (SELECT t.id FROM tasks t WHERE `+readyCondition+` LIMIT 1)
+		 RETURNING `+taskColumns,
+		workerID, time.Now().UTC().Add(lease), time.Now().UTC()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE expressions SET status = 'processing', started_at = CURRENT_TIMESTAMP
+		 WHERE id = ? AND status = 'pending'`,
+		t.ExpressionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// RenewLease продлевает аренду задачи, пока воркер продолжает ее выполнять
+func (r *SQLiteRepository) RenewLease(ctx context.Context, taskID, workerID string, lease time.Duration) error {
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE tasks SET lease_expires_at = ?
+		 WHERE id = ? AND worker_id = ? AND status = 'processing'`,
+		time.Now().UTC().Add(lease), taskID, workerID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// leaseExpiredError причина отправки в dead letter задачи, которую не завершил ни один из взявших ее воркеров
+const leaseExpiredError = "lease expired: worker did not finish the task"
+
+// ReclaimExpiredTasks возвращает в очередь задачи, аренда которых истекла
+// (например, воркер упал, не дописав результат). Задачи, которые брали в работу
+// уже maxAttempts раз, вместо этого уходят в dead letter, как в DeadLetterTask;
+// их ID возвращаются в dead. Задачи упавших выражений отменяются.
+func (r *SQLiteRepository) ReclaimExpiredTasks(ctx context.Context, maxAttempts int) (reclaimed int64, dead []string, err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	dead, err = queryIDs(ctx, tx,
+		`SELECT id FROM tasks
+		 WHERE status = 'processing' AND julianday(lease_expires_at) < julianday(?) AND attempts >= ?`,
+		now, maxAttempts)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	for _, id := range dead {
+		_, err := tx.ExecContext(ctx,
+			`UPDATE tasks SET
+				status = 'dead',
+				last_error = ?,
+				lease_expires_at = NULL,
+				completed_at = CURRENT_TIMESTAMP
+			 WHERE id = ?`,
+			leaseExpiredError, id)
+		if err != nil {
+			return 0, nil, err
+		}
+		if err := failExpression(ctx, tx, id); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	// Выражение уже упало (в том числе только что): его задачи не возвращаются в
+	// очередь, а отменяются, как ожидавшие задачи в failExpression
+	_, err = tx.ExecContext(ctx,
+		`UPDATE tasks SET
+			status = 'canceled',
+			worker_id = NULL,
+			lease_expires_at = NULL
+		 WHERE status = 'processing' AND julianday(lease_expires_at) < julianday(?)
+		   AND expression_id IN (SELECT id FROM expressions WHERE status = 'failed')`,
+		now)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	res, err := tx.ExecContext(ctx,
+		`UPDATE tasks SET
+			status = 'pending',
+			worker_id = NULL,
+			lease_expires_at = NULL,
+			started_at = NULL
+		 WHERE status = 'processing' AND julianday(lease_expires_at) < julianday(?)`,
+		now)
+	if err != nil {
+		return 0, nil, err
+	}
+	if reclaimed, err = res.RowsAffected(); err != nil {
+		return 0, nil, err
+	}
+	return reclaimed, dead, tx.Commit()
+}
+
+// RetryTask возвращает упавшую у воркера workerID задачу в очередь; снова взять ее можно
+// не раньше nextAttemptAt. Если задача уже не в аренде у workerID, возвращается ErrLeaseLost.
+func (r *SQLiteRepository) RetryTask(ctx context.Context, taskID, workerID, lastError string, nextAttemptAt time.Time) error {
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE tasks SET
+			status = 'pending',
+			last_error = ?,
+			next_attempt_at = ?,
+			worker_id = NULL,
+			lease_expires_at = NULL,
+			started_at = NULL
+		 WHERE id = ? AND worker_id = ? AND status = 'processing'`,
+		lastError, nextAttemptAt.UTC(), taskID, workerID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// DeadLetterTask переводит задачу воркера workerID, исчерпавшую попытки, в статус dead,
+// а ее выражение - в failed
+func (r *SQLiteRepository) DeadLetterTask(ctx context.Context, taskID, workerID, lastError string) error {
+	return r.finishFailedTask(ctx, taskID, workerID, "dead", lastError)
+}
+
+// FailTask завершает задачу с окончательной ошибкой (повтор не поможет,
+// например деление на ноль) и переводит ее выражение в failed с этой ошибкой
+func (r *SQLiteRepository) FailTask(ctx context.Context, taskID, workerID, lastError string) error {
+	return r.finishFailedTask(ctx, taskID, workerID, "failed", lastError)
+}
+
+// finishFailedTask завершает задачу со статусом status, только если она еще в аренде
+// у workerID, иначе возвращает ErrLeaseLost
+func (r *SQLiteRepository) finishFailedTask(ctx context.Context, taskID, workerID, status, lastError string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`UPDATE tasks SET
+			status = ?,
+			last_error = ?,
+			lease_expires_at = NULL,
+			completed_at = CURRENT_TIMESTAMP
+		 WHERE id = ? AND worker_id = ? AND status = 'processing'`,
+		status, lastError, taskID, workerID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrLeaseLost
+	}
+
+	if err := failExpression(ctx, tx, taskID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetDeadTasks возвращает задачи из очереди недоставленных (dead letter)
+func (r *SQLiteRepository) GetDeadTasks(ctx context.Context) ([]models.Task, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+taskColumns+` FROM tasks WHERE status = 'dead' ORDER BY completed_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []models.Task
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, *t)
+	}
+	return tasks, rows.Err()
+}
+
+// RequeueDeadTask возвращает задачу из dead letter в очередь вместе с остальными
+// задачами ее выражения в dead letter (со сброшенным счетчиком попыток) и
+// отмененными задачами, а выражение - в работу; ID всех возвращенных задач
+// возвращаются в requeued. Если в выражении есть задача, упавшая с окончательной
+// ошибкой, ничего не меняется и возвращается ErrRequeueBlocked с ее ID.
+func (r *SQLiteRepository) RequeueDeadTask(ctx context.Context, taskID string) (requeued []string, err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var exprID string
+	err = tx.QueryRowContext(ctx,
+		"SELECT expression_id FROM tasks WHERE id = ? AND status = 'dead'", taskID).Scan(&exprID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTaskNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	failed, err := queryIDs(ctx, tx, "SELECT id FROM tasks WHERE expression_id = ? AND status = 'failed'", exprID)
+	if err != nil {
+		return nil, err
+	}
+	if len(failed) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrRequeueBlocked, strings.Join(failed, ", "))
+	}
+
+	requeued, err = queryIDs(ctx, tx,
+		`UPDATE tasks SET
+			status = 'pending',
+			attempts = CASE WHEN status = 'dead' THEN 0 ELSE attempts END,
+			next_attempt_at = NULL,
+			worker_id = NULL,
+			lease_expires_at = NULL,
+			started_at = NULL,
+			completed_at = NULL
+		 WHERE expression_id = ? AND status IN ('dead', 'canceled')
+		 RETURNING id`,
+		exprID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE expressions SET
+			status = CASE WHEN started_at IS NULL THEN 'pending' ELSE 'processing' END,
+			error = NULL,
+			completed_at = NULL
+		 WHERE id = ? AND status = 'failed'`,
+		exprID)
+	if err != nil {
+		return nil, err
+	}
+
+	return requeued, tx.Commit()
+}
+
+// queryIDs выполняет запрос, возвращающий одну колонку ID
+func queryIDs(ctx context.Context, tx *sql.Tx, query string, args ...any) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// UpdateTaskResult сохраняет результат задачи и подставляет его в аргументы
+// зависящих от нее задач. Если задача корневая, результат становится
+// результатом выражения и выражение завершается. Результат принимается только
+// для задачи в аренде у workerID: если аренду уже отобрали, возвращается ErrLeaseLost.
+func (r *SQLiteRepository) UpdateTaskResult(ctx context.Context, taskID, workerID string, result float64) error {
+	return r.completeTask(ctx, taskID, workerID, result, "", "")
+}
+
+// UpdateTaskExactResult как UpdateTaskResult для задачи точного режима: точный
+// результат exact подставляется в точные аргументы, а result - его приближение.
+// decimal - десятичная запись результата, сохраняемая в выражение, если задача корневая.
+func (r *SQLiteRepository) UpdateTaskExactResult(ctx context.Context, taskID, workerID string, result float64, exact, decimal string) error {
+	return r.completeTask(ctx, taskID, workerID, result, exact, decimal)
+}
+
+func (r *SQLiteRepository) completeTask(ctx context.Context, taskID, workerID string, result float64, exact, decimal string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
 		`UPDATE tasks SET 
 			status = 'completed', 
 			result = ?,
+			exact_result = ?,
+			lease_expires_at = NULL,
 			completed_at = CURRENT_TIMESTAMP 
-		 WHERE id = ?`,
-		result, taskID)
+		 WHERE id = ? AND worker_id = ? AND status = 'processing'`,
+		result, nullString(exact), taskID, workerID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrLeaseLost
+	}
+
+	res1, err := tx.ExecContext(ctx,
+		"UPDATE tasks SET arg1 = ?, exact_arg1 = ? WHERE arg1_task_id = ?", result, nullString(exact), taskID)
+	if err != nil {
+		return err
+	}
+	res2, err := tx.ExecContext(ctx,
+		"UPDATE tasks SET arg2 = ?, exact_arg2 = ? WHERE arg2_task_id = ?", result, nullString(exact), taskID)
+	if err != nil {
+		return err
+	}
+	n1, err := res1.RowsAffected()
+	if err != nil {
+		return err
+	}
+	n2, err := res2.RowsAffected()
+	if err != nil {
+		return err
+	}
+	n3, err := substituteCallArgs(ctx, tx, taskID, result, exact)
+	if err != nil {
+		return err
+	}
+
+	if n1+n2+n3 == 0 {
+		var exprID string
+		err := tx.QueryRowContext(ctx, "SELECT expression_id FROM tasks WHERE id = ?", taskID).Scan(&exprID)
+		if err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx,
+			`UPDATE expressions SET
+				status = 'completed',
+				result = ?,
+				exact_result = ?,
+				decimal_result = ?,
+				completed_at = CURRENT_TIMESTAMP
+			 WHERE id = ? AND status IN ('pending', 'processing')`,
+			result, nullString(exact), nullString(decimal), exprID)
+		if err != nil {
+			return err
+		}
+		if err := enqueueWebhookIfFinished(ctx, tx, exprID, res); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// failExpression помечает выражение упавшей задачи как failed с ошибкой этой задачи
+// и отменяет его ожидающие задачи
+func failExpression(ctx context.Context, tx *sql.Tx, taskID string) error {
+	var exprID string
+	var lastError sql.NullString
+	err := tx.QueryRowContext(ctx, "SELECT expression_id, last_error FROM tasks WHERE id = ?", taskID).
+		Scan(&exprID, &lastError)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx,
+		`UPDATE expressions SET status = 'failed', error = ?, completed_at = CURRENT_TIMESTAMP
+		 WHERE id = ? AND status IN ('pending', 'processing')`,
+		lastError, exprID)
+	if err != nil {
+		return err
+	}
+	if err := enqueueWebhookIfFinished(ctx, tx, exprID, res); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE tasks SET status = 'canceled' WHERE expression_id = ? AND status = 'pending'",
+		exprID)
 	return err
 }
 
-func (r *SQLiteRepository) UpdateTaskStatus(ctx context.Context, taskID, status string) error {
-	query := "UPDATE tasks SET status = ?"
-	args := []interface{}{status}
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
 
-	switch status {
-	case "processing":
-		query += ", started_at = CURRENT_TIMESTAMP"
-	case "completed":
-		query += ", completed_at = CURRENT_TIMESTAMP"
-	case "failed":
-		query += ", completed_at = CURRENT_TIMESTAMP"
+func nullFloatPtr(f sql.NullFloat64) *float64 {
+	if !f.Valid {
+		return nil
 	}
+	return &f.Float64
+}
 
-	query += " WHERE id = ?"
-	args = append(args, taskID)
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
 
-	_, err := r.db.ExecContext(ctx, query, args...)
-	return err
+// encodeJSON записывает map в JSON-колонку; пустой map хранится как NULL
+func encodeJSON[M ~map[string]V, V any](m M) (sql.NullString, error) {
+	if len(m) == 0 {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+// decodeJSON читает JSON-колонку в v; NULL оставляет v без изменений
+func decodeJSON(column sql.NullString, v any) error {
+	if !column.Valid {
+		return nil
+	}
+	return json.Unmarshal([]byte(column.String), v)
 }
 
 func (r *SQLiteRepository) Close() error {
@@ -0,0 +1,525 @@
+package db
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m1tka051209/calculator-service/calculator"
+	"github.com/m1tka051209/calculator-service/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRepository(t *testing.T) *SQLiteRepository {
+	t.Helper()
+	repo, err := NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { repo.Close() })
+	return repo
+}
+
+func TestCreateUser(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	require.NoError(t, repo.CreateUser(ctx, "alice", "hash"))
+	assert.ErrorIs(t, repo.CreateUser(ctx, "alice", "other"), ErrUserExists)
+
+	user, err := repo.GetUserByLogin(ctx, "alice")
+	require.NoError(t, err)
+	assert.NotEmpty(t, user.ID)
+	assert.Equal(t, "hash", user.PasswordHash)
+
+	_, err = repo.GetUserByLogin(ctx, "bob")
+	assert.ErrorIs(t, err, ErrUserNotFound)
+}
+
+func TestCreateExpression(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	plan, err := calculator.Decompose("2+2*2")
+	require.NoError(t, err)
+
+	id, err := repo.CreateExpression(ctx, &models.Expression{UserID: "user1", Expression: "2+2*2"}, plan.Tasks)
+	require.NoError(t, err)
+	assert.NotEmpty(t, id)
+
+	var count int
+	require.NoError(t, repo.db.QueryRow("SELECT COUNT(*) FROM tasks WHERE expression_id = ?", id).Scan(&count))
+	assert.Equal(t, 2, count)
+
+	var dep string
+	require.NoError(t, repo.db.QueryRow("SELECT arg2_task_id FROM tasks WHERE id = ?", plan.Tasks[1].ID).Scan(&dep))
+	assert.Equal(t, plan.Tasks[0].ID, dep)
+}
+
+func TestCreateExpressionRollsBack(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	tasks := []models.Task{{ID: "dup", Operation: "+"}, {ID: "dup", Operation: "+"}}
+	_, err := repo.CreateExpression(ctx, &models.Expression{UserID: "user1", Expression: "1+1"}, tasks)
+	assert.Error(t, err)
+
+	var count int
+	require.NoError(t, repo.db.QueryRow("SELECT COUNT(*) FROM expressions").Scan(&count))
+	assert.Zero(t, count)
+}
+
+func createTestExpression(t *testing.T, repo *SQLiteRepository, expr string) (string, *calculator.Plan) {
+	t.Helper()
+	plan, err := calculator.Decompose(expr)
+	require.NoError(t, err)
+	id, err := repo.CreateExpression(context.Background(), &models.Expression{UserID: "user1", Expression: expr}, plan.Tasks)
+	require.NoError(t, err)
+	return id, plan
+}
+
+func TestTaskDependenciesAndResult(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+	_, plan := createTestExpression(t, repo, "2+2*2")
+	mul, add := plan.Tasks[0], plan.Tasks[1]
+
+	ready, err := repo.GetPendingTasks(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, ready, 1)
+	assert.Equal(t, mul.ID, ready[0].ID)
+
+	claimed, err := repo.ClaimTask(ctx, "w1", time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, claimed)
+	assert.Equal(t, mul.ID, claimed.ID)
+	exprs, err := repo.GetExpressionsByUser(ctx, "user1")
+	require.NoError(t, err)
+	assert.Equal(t, "processing", exprs[0].Status)
+	assert.NotNil(t, exprs[0].StartedAt)
+
+	require.NoError(t, repo.UpdateTaskResult(ctx, mul.ID, "w1", 4))
+	ready, err = repo.GetPendingTasks(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, ready, 1)
+	assert.Equal(t, add.ID, ready[0].ID)
+	assert.Equal(t, 2.0, ready[0].Arg1)
+	assert.Equal(t, 4.0, ready[0].Arg2)
+
+	_, err = repo.ClaimTask(ctx, "w1", time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, repo.UpdateTaskResult(ctx, add.ID, "w1", 6))
+	exprs, err = repo.GetExpressionsByUser(ctx, "user1")
+	require.NoError(t, err)
+	assert.Equal(t, "completed", exprs[0].Status)
+	require.NotNil(t, exprs[0].Result)
+	assert.Equal(t, 6.0, *exprs[0].Result)
+	assert.NotNil(t, exprs[0].CompletedAt)
+}
+
+func TestFunctionTaskArguments(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+	_, plan := createTestExpression(t, repo, "max(1, 2*3, 4)")
+	mul, call := plan.Tasks[0], plan.Tasks[1]
+
+	ready, err := repo.GetPendingTasks(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, ready, 1)
+	assert.Equal(t, mul.ID, ready[0].ID)
+
+	_, err = repo.ClaimTask(ctx, "w1", time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, repo.UpdateTaskResult(ctx, mul.ID, "w1", 6))
+
+	claimed, err := repo.ClaimTask(ctx, "w1", time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, claimed)
+	assert.Equal(t, call.ID, claimed.ID)
+	assert.Equal(t, "max", claimed.Operation)
+	assert.Equal(t, []float64{1, 6, 4}, claimed.Args)
+	assert.Equal(t, []string{"", mul.ID, ""}, claimed.ArgTaskIDs)
+
+	require.NoError(t, repo.UpdateTaskResult(ctx, call.ID, "w1", 6))
+	exprs, err := repo.GetExpressionsByUser(ctx, "user1")
+	require.NoError(t, err)
+	assert.Equal(t, "completed", exprs[0].Status)
+	require.NotNil(t, exprs[0].Result)
+	assert.Equal(t, 6.0, *exprs[0].Result)
+}
+
+func TestFailedTaskFailsExpression(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+	_, plan := createTestExpression(t, repo, "1+2+3")
+
+	task, err := repo.ClaimTask(ctx, "w1", time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, plan.Tasks[0].ID, task.ID)
+	require.NoError(t, repo.FailTask(ctx, task.ID, "w1", "boom"))
+
+	exprs, err := repo.GetExpressionsByUser(ctx, "user1")
+	require.NoError(t, err)
+	assert.Equal(t, "failed", exprs[0].Status)
+
+	ready, err := repo.GetPendingTasks(ctx, 10)
+	require.NoError(t, err)
+	assert.Empty(t, ready)
+}
+
+func TestClaimTaskIsExclusive(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		createTestExpression(t, repo, "1+1")
+	}
+
+	var mu sync.Mutex
+	claimed := map[string]int{}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			task, err := repo.ClaimTask(ctx, "w", time.Minute)
+			assert.NoError(t, err)
+			if task != nil {
+				mu.Lock()
+				claimed[task.ID]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, claimed, 5)
+	for id, n := range claimed {
+		assert.Equal(t, 1, n, "task %s claimed more than once", id)
+	}
+}
+
+func TestReclaimExpiredTasks(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+	_, plan := createTestExpression(t, repo, "1+1")
+	taskID := plan.Tasks[0].ID
+
+	_, err := repo.ClaimTask(ctx, "w1", -time.Second)
+	require.NoError(t, err)
+	assert.ErrorIs(t, repo.RenewLease(ctx, taskID, "w2", time.Minute), ErrLeaseLost)
+
+	n, dead, err := repo.ReclaimExpiredTasks(ctx, 3)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, n)
+	assert.Empty(t, dead)
+	assert.ErrorIs(t, repo.RenewLease(ctx, taskID, "w1", time.Minute), ErrLeaseLost)
+	assert.ErrorIs(t, repo.UpdateTaskResult(ctx, taskID, "w1", 2), ErrLeaseLost)
+
+	task, err := repo.ClaimTask(ctx, "w2", time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, task)
+	assert.Equal(t, taskID, task.ID)
+	assert.NoError(t, repo.RenewLease(ctx, taskID, "w2", time.Minute))
+}
+
+func TestFinishTaskChecksWorker(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+	_, plan := createTestExpression(t, repo, "1+2+3")
+	taskID := plan.Tasks[0].ID
+
+	// Аренду w1 отобрали, задачу взял w2: запоздавший w1 ничего не меняет
+	_, err := repo.ClaimTask(ctx, "w1", -time.Second)
+	require.NoError(t, err)
+	_, _, err = repo.ReclaimExpiredTasks(ctx, 3)
+	require.NoError(t, err)
+	task, err := repo.ClaimTask(ctx, "w2", time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, taskID, task.ID)
+
+	assert.ErrorIs(t, repo.UpdateTaskResult(ctx, taskID, "w1", 3), ErrLeaseLost)
+	assert.ErrorIs(t, repo.UpdateTaskExactResult(ctx, taskID, "w1", 3, "3", "3"), ErrLeaseLost)
+	assert.ErrorIs(t, repo.RetryTask(ctx, taskID, "w1", "boom", time.Now()), ErrLeaseLost)
+	assert.ErrorIs(t, repo.DeadLetterTask(ctx, taskID, "w1", "boom"), ErrLeaseLost)
+	assert.ErrorIs(t, repo.FailTask(ctx, taskID, "w1", "boom"), ErrLeaseLost)
+
+	task, err = repo.GetTask(ctx, taskID)
+	require.NoError(t, err)
+	assert.Equal(t, "processing", task.Status)
+	assert.Equal(t, "w2", task.WorkerID)
+	require.NoError(t, repo.UpdateTaskResult(ctx, taskID, "w2", 3))
+}
+
+func TestReclaimExpiredTasksDeadLetter(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+	exprID, plan := createTestExpression(t, repo, "1+2+3")
+	taskID := plan.Tasks[0].ID
+
+	const maxAttempts = 3
+	for i := 1; i <= maxAttempts; i++ {
+		task, err := repo.ClaimTask(ctx, "w1", -time.Second)
+		require.NoError(t, err)
+		require.NotNil(t, task)
+		require.Equal(t, i, task.Attempts)
+
+		n, dead, err := repo.ReclaimExpiredTasks(ctx, maxAttempts)
+		require.NoError(t, err)
+		if i < maxAttempts {
+			assert.EqualValues(t, 1, n)
+			assert.Empty(t, dead)
+		} else {
+			assert.EqualValues(t, 0, n)
+			assert.Equal(t, []string{taskID}, dead)
+		}
+	}
+
+	task, err := repo.GetTask(ctx, taskID)
+	require.NoError(t, err)
+	assert.Equal(t, "dead", task.Status)
+	assert.NotEmpty(t, task.LastError)
+
+	got, err := repo.GetExpression(ctx, exprID)
+	require.NoError(t, err)
+	assert.Equal(t, "failed", got.Status)
+
+	task, err = repo.ClaimTask(ctx, "w1", time.Minute)
+	require.NoError(t, err)
+	assert.Nil(t, task)
+}
+
+func TestReclaimExpiredTasksOfFailedExpression(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+	createTestExpression(t, repo, "1/0 + 2*3")
+
+	// Обе задачи в работе, у второй аренда истекла уже после падения выражения
+	failing, err := repo.ClaimTask(ctx, "w1", time.Minute)
+	require.NoError(t, err)
+	sibling, err := repo.ClaimTask(ctx, "w2", -time.Second)
+	require.NoError(t, err)
+	require.NotNil(t, sibling)
+	require.NoError(t, repo.FailTask(ctx, failing.ID, "w1", "1 / 0: division by zero"))
+
+	n, dead, err := repo.ReclaimExpiredTasks(ctx, 3)
+	require.NoError(t, err)
+	assert.EqualValues(t, 0, n)
+	assert.Empty(t, dead)
+
+	task, err := repo.GetTask(ctx, sibling.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "canceled", task.Status)
+	assert.Empty(t, task.WorkerID)
+
+	task, err = repo.ClaimTask(ctx, "w3", time.Minute)
+	require.NoError(t, err)
+	assert.Nil(t, task, "tasks of a failed expression must not be requeued")
+}
+
+func TestRetryAndDeadLetter(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+	_, plan := createTestExpression(t, repo, "1+2+3")
+	taskID := plan.Tasks[0].ID
+
+	task, err := repo.ClaimTask(ctx, "w1", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 1, task.Attempts)
+
+	require.NoError(t, repo.RetryTask(ctx, taskID, "w1", "boom", time.Now().Add(time.Hour)))
+	task, err = repo.ClaimTask(ctx, "w1", time.Minute)
+	require.NoError(t, err)
+	assert.Nil(t, task, "task must not be claimable before next_attempt_at")
+
+	_, err = repo.db.Exec("UPDATE tasks SET next_attempt_at = ? WHERE id = ?", time.Now().Add(-time.Second).UTC(), taskID)
+	require.NoError(t, err)
+	task, err = repo.ClaimTask(ctx, "w1", time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, task)
+	require.NoError(t, repo.DeadLetterTask(ctx, taskID, "w1", "boom again"))
+
+	dead, err := repo.GetDeadTasks(ctx)
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, taskID, dead[0].ID)
+	assert.Equal(t, 2, dead[0].Attempts)
+	assert.Equal(t, "boom again", dead[0].LastError)
+
+	exprs, err := repo.GetExpressionsByUser(ctx, "user1")
+	require.NoError(t, err)
+	assert.Equal(t, "failed", exprs[0].Status)
+
+	requeued, err := repo.RequeueDeadTask(ctx, taskID)
+	require.NoError(t, err)
+	assert.Len(t, requeued, 2, "the dead task and its canceled sibling")
+	_, err = repo.RequeueDeadTask(ctx, taskID)
+	assert.ErrorIs(t, err, ErrTaskNotFound)
+
+	exprs, err = repo.GetExpressionsByUser(ctx, "user1")
+	require.NoError(t, err)
+	assert.Equal(t, "processing", exprs[0].Status)
+
+	task, err = repo.ClaimTask(ctx, "w1", time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, task)
+	assert.Equal(t, taskID, task.ID)
+	assert.Equal(t, 1, task.Attempts)
+}
+
+func TestRequeueDeadTaskRequeuesSiblings(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+	exprID, plan := createTestExpression(t, repo, "1*2 + 3*4")
+
+	first, err := repo.ClaimTask(ctx, "w1", time.Minute)
+	require.NoError(t, err)
+	second, err := repo.ClaimTask(ctx, "w2", time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, repo.DeadLetterTask(ctx, first.ID, "w1", "boom"))
+	require.NoError(t, repo.DeadLetterTask(ctx, second.ID, "w2", "boom"))
+
+	requeued, err := repo.RequeueDeadTask(ctx, first.ID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{plan.Tasks[0].ID, plan.Tasks[1].ID, plan.Tasks[2].ID}, requeued)
+
+	dead, err := repo.GetDeadTasks(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, dead)
+	ready, err := repo.GetPendingTasks(ctx, 10)
+	require.NoError(t, err)
+	assert.Len(t, ready, 2)
+	got, err := repo.GetExpression(ctx, exprID)
+	require.NoError(t, err)
+	assert.Equal(t, "processing", got.Status)
+}
+
+func TestRequeueDeadTaskBlockedByFailedTask(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+	exprID, _ := createTestExpression(t, repo, "1/0 + 2*3")
+
+	failing, err := repo.ClaimTask(ctx, "w1", time.Minute)
+	require.NoError(t, err)
+	sibling, err := repo.ClaimTask(ctx, "w2", time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, repo.FailTask(ctx, failing.ID, "w1", "division by zero"))
+	require.NoError(t, repo.DeadLetterTask(ctx, sibling.ID, "w2", "boom"))
+
+	_, err = repo.RequeueDeadTask(ctx, sibling.ID)
+	assert.ErrorIs(t, err, ErrRequeueBlocked)
+	assert.ErrorContains(t, err, failing.ID)
+
+	task, err := repo.GetTask(ctx, sibling.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "dead", task.Status)
+	got, err := repo.GetExpression(ctx, exprID)
+	require.NoError(t, err)
+	assert.Equal(t, "failed", got.Status)
+}
+
+func TestFailTaskSurfacesError(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+	_, plan := createTestExpression(t, repo, "1/0+1")
+
+	task, err := repo.ClaimTask(ctx, "w1", time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, repo.FailTask(ctx, task.ID, "w1", "1 / 0: division by zero"))
+
+	exprs, err := repo.GetExpressionsByUser(ctx, "user1")
+	require.NoError(t, err)
+	assert.Equal(t, "failed", exprs[0].Status)
+	assert.Equal(t, "1 / 0: division by zero", exprs[0].Error)
+
+	var status string
+	require.NoError(t, repo.db.QueryRow("SELECT status FROM tasks WHERE id = ?", plan.Tasks[1].ID).Scan(&status))
+	assert.Equal(t, "canceled", status)
+}
+
+func TestGetExpressionWithTasks(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+	id, plan := createTestExpression(t, repo, "2+2*2")
+
+	_, err := repo.ClaimTask(ctx, "w1", time.Minute)
+	require.NoError(t, err)
+
+	expr, err := repo.GetExpression(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "user1", expr.UserID)
+	assert.Equal(t, "processing", expr.Status)
+
+	tasks, err := repo.GetExpressionTasks(ctx, id)
+	require.NoError(t, err)
+	require.Len(t, tasks, 2)
+	assert.Equal(t, plan.Tasks[0].ID, tasks[0].ID)
+	assert.Equal(t, "w1", tasks[0].WorkerID)
+	assert.NotNil(t, tasks[0].StartedAt)
+	assert.NotNil(t, tasks[0].LeaseExpiresAt)
+	assert.Equal(t, "pending", tasks[1].Status)
+	assert.Nil(t, tasks[1].StartedAt)
+
+	_, err = repo.GetExpression(ctx, "missing")
+	assert.ErrorIs(t, err, ErrExpressionNotFound)
+}
+
+func TestVariables(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	require.NoError(t, repo.SetVariable(ctx, &models.Variable{UserID: "user1", Name: "rate", Value: 0.13}))
+	require.NoError(t, repo.SetVariable(ctx, &models.Variable{UserID: "user1", Name: "price", Value: 100}))
+	require.NoError(t, repo.SetVariable(ctx, &models.Variable{UserID: "user2", Name: "rate", Value: 0.2}))
+
+	v := &models.Variable{UserID: "user1", Name: "rate", Value: 0.15}
+	require.NoError(t, repo.SetVariable(ctx, v))
+	assert.False(t, v.UpdatedAt.IsZero())
+
+	got, err := repo.GetVariable(ctx, "user1", "rate")
+	require.NoError(t, err)
+	assert.Equal(t, 0.15, got.Value)
+
+	vars, err := repo.GetVariables(ctx, "user1")
+	require.NoError(t, err)
+	require.Len(t, vars, 2)
+	assert.Equal(t, "price", vars[0].Name)
+	assert.Equal(t, "rate", vars[1].Name)
+
+	require.NoError(t, repo.DeleteVariable(ctx, "user1", "rate"))
+	assert.ErrorIs(t, repo.DeleteVariable(ctx, "user1", "rate"), ErrVariableNotFound)
+	_, err = repo.GetVariable(ctx, "user1", "rate")
+	assert.ErrorIs(t, err, ErrVariableNotFound)
+
+	other, err := repo.GetVariable(ctx, "user2", "rate")
+	require.NoError(t, err)
+	assert.Equal(t, 0.2, other.Value)
+}
+
+func TestFunctions(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	fn := &models.UserFunction{UserID: "user1", Name: "vat", Params: []string{"x"}, Body: "x * 0.2"}
+	require.NoError(t, repo.SetFunction(ctx, fn))
+	assert.False(t, fn.UpdatedAt.IsZero())
+	require.NoError(t, repo.SetFunction(ctx,
+		&models.UserFunction{UserID: "user1", Name: "vat", Params: []string{"x", "rate"}, Body: "x * rate"}))
+	require.NoError(t, repo.SetFunction(ctx, &models.UserFunction{UserID: "user1", Name: "answer", Params: []string{}, Body: "42"}))
+
+	got, err := repo.GetFunction(ctx, "user1", "vat")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"x", "rate"}, got.Params)
+	assert.Equal(t, "x * rate", got.Body)
+
+	fns, err := repo.GetFunctions(ctx, "user1")
+	require.NoError(t, err)
+	require.Len(t, fns, 2)
+	assert.Equal(t, "answer", fns[0].Name)
+	assert.Empty(t, fns[0].Params)
+
+	_, err = repo.GetFunction(ctx, "user2", "vat")
+	assert.ErrorIs(t, err, ErrFunctionNotFound)
+	require.NoError(t, repo.DeleteFunction(ctx, "user1", "vat"))
+	assert.ErrorIs(t, repo.DeleteFunction(ctx, "user1", "vat"), ErrFunctionNotFound)
+}
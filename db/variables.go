@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/m1tka051209/calculator-service/models"
+)
+
+// SetVariable создает переменную пользователя или меняет значение существующей
+func (r *SQLiteRepository) SetVariable(ctx context.Context, v *models.Variable) error {
+	return r.db.QueryRowContext(ctx,
+		`INSERT INTO variables(user_id, name, value) VALUES(?, ?, ?)
+		 ON CONFLICT(user_id, name) DO UPDATE SET
+			value = excluded.value,
+			updated_at = CURRENT_TIMESTAMP
+		 RETURNING updated_at`,
+		v.UserID, v.Name, v.Value).Scan(&v.UpdatedAt)
+}
+
+// GetVariable возвращает переменную пользователя по имени или ErrVariableNotFound
+func (r *SQLiteRepository) GetVariable(ctx context.Context, userID, name string) (*models.Variable, error) {
+	v := models.Variable{UserID: userID, Name: name}
+	err := r.db.QueryRowContext(ctx,
+		"SELECT value, updated_at FROM variables WHERE user_id = ? AND name = ?", userID, name).
+		Scan(&v.Value, &v.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrVariableNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// GetVariables возвращает переменные пользователя в порядке имен
+func (r *SQLiteRepository) GetVariables(ctx context.Context, userID string) ([]models.Variable, error) {
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT name, value, updated_at FROM variables WHERE user_id = ? ORDER BY name", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var vars []models.Variable
+	for rows.Next() {
+		v := models.Variable{UserID: userID}
+		if err := rows.Scan(&v.Name, &v.Value, &v.UpdatedAt); err != nil {
+			return nil, err
+		}
+		vars = append(vars, v)
+	}
+	return vars, rows.Err()
+}
+
+// DeleteVariable удаляет переменную пользователя; отсутствующая - ErrVariableNotFound
+func (r *SQLiteRepository) DeleteVariable(ctx context.Context, userID, name string) error {
+	res, err := r.db.ExecContext(ctx, "DELETE FROM variables WHERE user_id = ? AND name = ?", userID, name)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrVariableNotFound
+	}
+	return nil
+}
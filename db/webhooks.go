@@ -0,0 +1,131 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/m1tka051209/calculator-service/models"
+)
+
+// enqueueWebhook ставит в очередь отправку завершенного выражения на его callback URL.
+// Для выражений без callback_url или еще не завершенных ничего не делает. Повторное
+// завершение (после возврата задачи из dead letter) отправляет выражение заново.
+func enqueueWebhook(ctx context.Context, tx *sql.Tx, exprID string) error {
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO webhook_deliveries(id, expression_id, url)
+		 SELECT ?, id, callback_url FROM expressions
+		 WHERE id = ? AND callback_url IS NOT NULL AND status IN ('completed', 'failed')
+		 ON CONFLICT(expression_id) DO UPDATE SET
+			status = 'pending',
+			attempts = 0,
+			last_error = NULL,
+			next_attempt_at = NULL,
+			delivered_at = NULL`,
+		uuid.New().String(), exprID)
+	return err
+}
+
+// enqueueWebhookIfFinished вызывает enqueueWebhook, если UPDATE с результатом res
+// действительно завершил выражение, а не застал его уже завершенным
+func enqueueWebhookIfFinished(ctx context.Context, tx *sql.Tx, exprID string, res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return err
+	}
+	return enqueueWebhook(ctx, tx, exprID)
+}
+
+// GetDueWebhooks возвращает ожидающие отправки доставки, время очередной попытки которых наступило
+func (r *SQLiteRepository) GetDueWebhooks(ctx context.Context, limit int) ([]models.WebhookDelivery, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id, expression_id, url, status, attempts, last_error, next_attempt_at, created_at
+		 FROM webhook_deliveries
+		 WHERE status = 'pending' AND (next_attempt_at IS NULL OR julianday(next_attempt_at) <= julianday(?))
+		 ORDER BY created_at LIMIT ?`, time.Now().UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []models.WebhookDelivery
+	for rows.Next() {
+		var d models.WebhookDelivery
+		var lastError sql.NullString
+		var nextAttemptAt sql.NullTime
+		err := rows.Scan(&d.ID, &d.ExpressionID, &d.URL, &d.Status, &d.Attempts, &lastError, &nextAttemptAt, &d.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		d.LastError = lastError.String
+		d.NextAttemptAt = nullTimePtr(nextAttemptAt)
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// RecordWebhookAttempt сохраняет попытку доставки и переводит доставку в status:
+// delivered, failed (попытки исчерпаны) или pending с повтором в nextAttemptAt
+func (r *SQLiteRepository) RecordWebhookAttempt(ctx context.Context, attempt *models.WebhookAttempt, status string, nextAttemptAt *time.Time) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var statusCode sql.NullInt64
+	if attempt.StatusCode != 0 {
+		statusCode = sql.NullInt64{Int64: int64(attempt.StatusCode), Valid: true}
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO webhook_attempts(delivery_id, attempt, status_code, error) VALUES(?, ?, ?, ?)`,
+		attempt.DeliveryID, attempt.Attempt, statusCode, nullString(attempt.Error))
+	if err != nil {
+		return err
+	}
+
+	var next sql.NullTime
+	if nextAttemptAt != nil {
+		next = sql.NullTime{Time: nextAttemptAt.UTC(), Valid: true}
+	}
+	_, err = tx.ExecContext(ctx,
+		`UPDATE webhook_deliveries SET
+			status = ?,
+			attempts = ?,
+			last_error = ?,
+			next_attempt_at = ?,
+			delivered_at = CASE WHEN ? = 'delivered' THEN CURRENT_TIMESTAMP END
+		 WHERE id = ?`,
+		status, attempt.Attempt, nullString(attempt.Error), next, status, attempt.DeliveryID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetWebhookAttempts возвращает попытки доставки выражения в порядке их выполнения
+func (r *SQLiteRepository) GetWebhookAttempts(ctx context.Context, expressionID string) ([]models.WebhookAttempt, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT a.delivery_id, a.attempt, a.status_code, a.error, a.created_at
+		 FROM webhook_attempts a JOIN webhook_deliveries d ON d.id = a.delivery_id
+		 WHERE d.expression_id = ? ORDER BY a.id`, expressionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []models.WebhookAttempt
+	for rows.Next() {
+		var a models.WebhookAttempt
+		var statusCode sql.NullInt64
+		var attemptErr sql.NullString
+		if err := rows.Scan(&a.DeliveryID, &a.Attempt, &statusCode, &attemptErr, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		a.StatusCode = int(statusCode.Int64)
+		a.Error = attemptErr.String
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
@@ -1,19 +1,32 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"os/signal"
+	"syscall"
 
 	"github.com/m1tka051209/calculator-service/api"
+	"github.com/m1tka051209/calculator-service/calculator"
 	"github.com/m1tka051209/calculator-service/config"
 	"github.com/m1tka051209/calculator-service/db"
 	"github.com/m1tka051209/calculator-service/server"
+	"github.com/m1tka051209/calculator-service/task_manager"
+	"github.com/m1tka051209/calculator-service/webhook"
 	"github.com/m1tka051209/calculator-service/worker"
 )
 
 func main() {
 	cfg := config.Load()
 
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := api.SetJWTSecret(cfg.JWTSecret); err != nil {
+		log.Fatal("JWT_SECRET is required: user tokens are signed with it")
+	}
+
 	// Инициализация репозитория
 	repo, err := db.NewSQLiteRepository(cfg.DBPath)
 	if err != nil {
@@ -21,18 +34,57 @@ func main() {
 	}
 	defer repo.Close()
 
+	// Менеджер задач и возврат в очередь задач упавших агентов
+	decimal := calculator.DecimalOptions{Scale: cfg.DecimalScale, Rounding: cfg.DecimalRounding}
+	if !calculator.ValidRounding(decimal.Rounding) || decimal.Scale < 0 || decimal.Scale > calculator.MaxScale {
+		log.Fatalf("Invalid DECIMAL_SCALE %d or DECIMAL_ROUNDING %q", decimal.Scale, decimal.Rounding)
+	}
+	optimize, err := calculator.ParseOptimizations(cfg.Optimizations)
+	if err != nil {
+		log.Fatalf("Invalid OPTIMIZATIONS: %v", err)
+	}
+	tm := task_manager.NewTaskManager(repo, cfg.TaskLease, task_manager.RetryPolicy{
+		MaxAttempts: cfg.TaskMaxAttempts,
+		BaseDelay:   cfg.RetryBaseDelay,
+		MaxDelay:    cfg.RetryMaxDelay,
+	}, cfg.OperationTimes, decimal, optimize)
+	go tm.RunReaper(ctx, cfg.ReaperInterval)
+
+	// Доставка завершенных выражений на callback URL
+	if cfg.WebhookSecret == "" {
+		log.Fatal("WEBHOOK_SECRET is required: webhook receivers verify signatures with it")
+	}
+	dispatcher := webhook.NewDispatcher(repo, cfg.WebhookSecret, task_manager.RetryPolicy{
+		MaxAttempts: cfg.WebhookMaxAttempts,
+		BaseDelay:   cfg.WebhookRetryBaseDelay,
+		MaxDelay:    cfg.WebhookRetryMaxDelay,
+	})
+	go dispatcher.Run(ctx, cfg.WebhookPollInterval)
+
 	// Запуск gRPC сервера
+	if cfg.AgentToken == "" {
+		log.Fatal("AGENT_TOKEN is required: agents authenticate to the gRPC server with it")
+	}
 	go func() {
-		if err := server.StartGRPCServer(cfg.GRPCPort, repo); err != nil {
+		if err := server.StartGRPCServer(cfg.GRPCPort, cfg.AgentToken, repo, tm); err != nil {
 			log.Fatalf("gRPC server failed: %v", err)
 		}
 	}()
 
 	// Запуск HTTP сервера
-	http.Handle("/", api.StartHTTPGateway())
+	http.Handle("/", api.StartHTTPGateway(repo, tm, cfg.AdminToken))
 	log.Println("HTTP server started on :8080")
 	go http.ListenAndServe(":8080", nil)
 
-	// Запуск воркеров
-	worker.RunWorker(repo, cfg.WorkerPoolSize)
-}
\ No newline at end of file
+	// Встроенный агент для запуска одним процессом; WORKER_POOL_SIZE=0 отключает его,
+	// тогда вычисления выполняют отдельные агенты (cmd/agent)
+	if cfg.WorkerPoolSize > 0 {
+		err := worker.RunWorker(ctx, "localhost:"+cfg.GRPCPort, cfg.AgentID, cfg.AgentToken, cfg.WorkerPoolSize, cfg.HeartbeatInterval)
+		if err != nil {
+			log.Fatalf("Local agent failed: %v", err)
+		}
+	} else {
+		<-ctx.Done()
+	}
+	log.Println("Shutting down...")
+}
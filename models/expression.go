@@ -7,8 +7,36 @@ type Expression struct {
 	UserID      string     `json:"user_id"`
 	Expression  string     `json:"expression"`
 	Status      string     `json:"status"`
-	Result      float64    `json:"result,omitempty"`
+	Result      *float64   `json:"result"` // nil, пока выражение не завершилось успешно
+	Error       string     `json:"error,omitempty"`
 	CreatedAt   time.Time  `json:"created_at"`
 	StartedAt   *time.Time `json:"started_at,omitempty"`
 	CompletedAt *time.Time `json:"completed_at,omitempty"`
-}
\ No newline at end of file
+	// CallbackURL куда отправить выражение после завершения
+	CallbackURL string `json:"callback_url,omitempty"`
+	// Precision режим вычисления: пустой (float64), "decimal" или "rational"
+	Precision string `json:"precision,omitempty"`
+	// Scale и Rounding число знаков после запятой и режим округления точных режимов
+	Scale    *int   `json:"scale,omitempty"`
+	Rounding string `json:"rounding,omitempty"`
+	// ExactResult точный результат строкой: десятичная дробь в режиме decimal,
+	// несократимая дробь вида 1/3 в режиме rational; Result - его приближение
+	ExactResult string `json:"exact_result,omitempty"`
+	// DecimalResult результат rational, округленный до Scale знаков: 0.3333333333
+	DecimalResult string `json:"decimal_result,omitempty"`
+	// Variables значения переменных и констант на момент отправки выражения,
+	// чтобы результат можно было воспроизвести после их изменения
+	Variables map[string]float64 `json:"variables,omitempty"`
+	// Functions определения пользовательских функций, подставленных в выражение
+	Functions map[string]string `json:"functions,omitempty"`
+	// EliminatedTasks сколько задач убрали упрощения выражения перед разложением
+	EliminatedTasks int `json:"eliminated_tasks,omitempty"`
+}
+
+// GetResult возвращает результат выражения или 0, если его еще нет
+func (e *Expression) GetResult() float64 {
+	if e.Result == nil {
+		return 0
+	}
+	return *e.Result
+}
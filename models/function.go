@@ -0,0 +1,21 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// UserFunction функция пользователя вида name(params) = body, которую можно
+// вызывать в его выражениях
+type UserFunction struct {
+	UserID    string    `json:"-"`
+	Name      string    `json:"name"`
+	Params    []string  `json:"params"`
+	Body      string    `json:"body"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Definition записывает функцию так, как ее определяют: vat(x) = x * 0.2
+func (f *UserFunction) Definition() string {
+	return f.Name + "(" + strings.Join(f.Params, ", ") + ") = " + f.Body
+}
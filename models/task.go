@@ -1,12 +1,46 @@
 package models
 
+import "time"
+
 type Task struct {
-	ID            string  `json:"id"`
-	ExpressionID  string  `json:"expression_id"`
-	Arg1          float64 `json:"arg1"`
-	Arg2          float64 `json:"arg2"`
-	Operation     string  `json:"operation"`
-	OperationTime int     `json:"operation_time"`
-	Status        string  `json:"status"`
-	Result        float64 `json:"result,omitempty"`
-}
\ No newline at end of file
+	ID            string     `json:"id"`
+	ExpressionID  string     `json:"expression_id"`
+	Arg1          float64    `json:"arg1"`
+	Arg1TaskID    string     `json:"arg1_task_id,omitempty"`
+	Arg2          float64    `json:"arg2"`
+	Arg2TaskID    string     `json:"arg2_task_id,omitempty"`
+	Operation     string     `json:"operation"`
+	OperationTime int        `json:"operation_time"`
+	Status        string     `json:"status"`
+	WorkerID      string     `json:"worker_id,omitempty"`
+	Result        *float64   `json:"result"` // nil, пока задача не выполнена
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"last_error,omitempty"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	// LeaseExpiresAt до какого момента задача закреплена за WorkerID
+	LeaseExpiresAt *time.Time `json:"lease_expires_at,omitempty"`
+	StartedAt      *time.Time `json:"started_at,omitempty"`
+	CompletedAt    *time.Time `json:"completed_at,omitempty"`
+	// Args и ArgTaskIDs аргументы задачи-вызова функции с любым числом аргументов;
+	// непустой ArgTaskIDs[i] означает, что Args[i] вычисляет другая задача
+	Args       []float64 `json:"args,omitempty"`
+	ArgTaskIDs []string  `json:"arg_task_ids,omitempty"`
+	// Precision, Scale и Rounding режим точности выражения задачи
+	Precision string `json:"precision,omitempty"`
+	Scale     int    `json:"scale,omitempty"`
+	Rounding  string `json:"rounding,omitempty"`
+	// ExactArg1, ExactArg2, ExactArgs и ExactResult точные значения строками в
+	// точных режимах; Arg1, Arg2, Args и Result тогда хранят их приближения
+	ExactArg1   string   `json:"exact_arg1,omitempty"`
+	ExactArg2   string   `json:"exact_arg2,omitempty"`
+	ExactArgs   []string `json:"exact_args,omitempty"`
+	ExactResult string   `json:"exact_result,omitempty"`
+}
+
+// GetResult возвращает результат задачи или 0, если его еще нет
+func (t *Task) GetResult() float64 {
+	if t.Result == nil {
+		return 0
+	}
+	return *t.Result
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// Variable именованное значение пользователя, подставляемое в его выражения
+type Variable struct {
+	UserID    string    `json:"-"`
+	Name      string    `json:"name"`
+	Value     float64   `json:"value"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// WebhookDelivery отправка результата выражения на его callback URL
+type WebhookDelivery struct {
+	ID            string     `json:"id"`
+	ExpressionID  string     `json:"expression_id"`
+	URL           string     `json:"url"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"last_error,omitempty"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty"`
+}
+
+// WebhookAttempt одна попытка доставки
+type WebhookAttempt struct {
+	DeliveryID string `json:"delivery_id"`
+	Attempt    int    `json:"attempt"`
+	// StatusCode HTTP-код ответа получателя, 0 если ответа не было
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
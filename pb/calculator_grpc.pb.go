@@ -0,0 +1,374 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.29.3
+// source: calculator.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Calculator_Calculate_FullMethodName        = "/calculator.Calculator/Calculate"
+	Calculator_CreateExpression_FullMethodName = "/calculator.Calculator/CreateExpression"
+	Calculator_GetExpressions_FullMethodName   = "/calculator.Calculator/GetExpressions"
+	Calculator_GetTask_FullMethodName          = "/calculator.Calculator/GetTask"
+	Calculator_StreamTasks_FullMethodName      = "/calculator.Calculator/StreamTasks"
+	Calculator_SubmitResult_FullMethodName     = "/calculator.Calculator/SubmitResult"
+	Calculator_Heartbeat_FullMethodName        = "/calculator.Calculator/Heartbeat"
+)
+
+// CalculatorClient is the client API for Calculator service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Calculator сервис оркестратора: выдача задач агентам, прием результатов
+// и работа с выражениями
+type CalculatorClient interface {
+	// Calculate выполняет одну операцию. Ошибки вычисления возвращаются кодами:
+	// INVALID_ARGUMENT (деление или остаток по нулю, NaN, аргумент вне области
+	// определения функции, неверное число аргументов, неизвестный режим точности),
+	// UNIMPLEMENTED (неизвестная операция), OUT_OF_RANGE (переполнение).
+	Calculate(ctx context.Context, in *CalculationRequest, opts ...grpc.CallOption) (*CalculationResponse, error)
+	// CreateExpression разбирает выражение и ставит его задачи в очередь
+	CreateExpression(ctx context.Context, in *ExpressionRequest, opts ...grpc.CallOption) (*ExpressionResponse, error)
+	// GetExpressions возвращает выражения пользователя
+	GetExpressions(ctx context.Context, in *GetExpressionsRequest, opts ...grpc.CallOption) (*GetExpressionsResponse, error)
+	// GetTask выдает агенту готовую задачу в аренду; task не заполнен, если задач нет
+	GetTask(ctx context.Context, in *GetTaskRequest, opts ...grpc.CallOption) (*GetTaskResponse, error)
+	// StreamTasks push-выдача задач: агент сообщает о свободных слотах, а оркестратор
+	// отправляет готовые задачи, как только они появляются, но не больше выданных слотов
+	StreamTasks(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[TaskSlots, Task], error)
+	// SubmitResult принимает результат или ошибку выполнения задачи.
+	// FAILED_PRECONDITION означает, что аренда задачи уже потеряна.
+	SubmitResult(ctx context.Context, in *SubmitResultRequest, opts ...grpc.CallOption) (*SubmitResultResponse, error)
+	// Heartbeat продлевает аренду задач, которые агент сейчас выполняет
+	Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error)
+}
+
+type calculatorClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCalculatorClient(cc grpc.ClientConnInterface) CalculatorClient {
+	return &calculatorClient{cc}
+}
+
+func (c *calculatorClient) Calculate(ctx context.Context, in *CalculationRequest, opts ...grpc.CallOption) (*CalculationResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CalculationResponse)
+	err := c.cc.Invoke(ctx, Calculator_Calculate_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *calculatorClient) CreateExpression(ctx context.Context, in *ExpressionRequest, opts ...grpc.CallOption) (*ExpressionResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ExpressionResponse)
+	err := c.cc.Invoke(ctx, Calculator_CreateExpression_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *calculatorClient) GetExpressions(ctx context.Context, in *GetExpressionsRequest, opts ...grpc.CallOption) (*GetExpressionsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetExpressionsResponse)
+	err := c.cc.Invoke(ctx, Calculator_GetExpressions_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *calculatorClient) GetTask(ctx context.Context, in *GetTaskRequest, opts ...grpc.CallOption) (*GetTaskResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetTaskResponse)
+	err := c.cc.Invoke(ctx, Calculator_GetTask_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *calculatorClient) StreamTasks(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[TaskSlots, Task], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Calculator_ServiceDesc.Streams[0], Calculator_StreamTasks_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[TaskSlots, Task]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Calculator_StreamTasksClient = grpc.BidiStreamingClient[TaskSlots, Task]
+
+func (c *calculatorClient) SubmitResult(ctx context.Context, in *SubmitResultRequest, opts ...grpc.CallOption) (*SubmitResultResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SubmitResultResponse)
+	err := c.cc.Invoke(ctx, Calculator_SubmitResult_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *calculatorClient) Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(HeartbeatResponse)
+	err := c.cc.Invoke(ctx, Calculator_Heartbeat_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CalculatorServer is the server API for Calculator service.
+// All implementations must embed UnimplementedCalculatorServer
+// for forward compatibility.
+//
+// Calculator сервис оркестратора: выдача задач агентам, прием результатов
+// и работа с выражениями
+type CalculatorServer interface {
+	// Calculate выполняет одну операцию. Ошибки вычисления возвращаются кодами:
+	// INVALID_ARGUMENT (деление или остаток по нулю, NaN, аргумент вне области
+	// определения функции, неверное число аргументов, неизвестный режим точности),
+	// UNIMPLEMENTED (неизвестная операция), OUT_OF_RANGE (переполнение).
+	Calculate(context.Context, *CalculationRequest) (*CalculationResponse, error)
+	// CreateExpression разбирает выражение и ставит его задачи в очередь
+	CreateExpression(context.Context, *ExpressionRequest) (*ExpressionResponse, error)
+	// GetExpressions возвращает выражения пользователя
+	GetExpressions(context.Context, *GetExpressionsRequest) (*GetExpressionsResponse, error)
+	// GetTask выдает агенту готовую задачу в аренду; task не заполнен, если задач нет
+	GetTask(context.Context, *GetTaskRequest) (*GetTaskResponse, error)
+	// StreamTasks push-выдача задач: агент сообщает о свободных слотах, а оркестратор
+	// отправляет готовые задачи, как только они появляются, но не больше выданных слотов
+	StreamTasks(grpc.BidiStreamingServer[TaskSlots, Task]) error
+	// SubmitResult принимает результат или ошибку выполнения задачи.
+	// FAILED_PRECONDITION означает, что аренда задачи уже потеряна.
+	SubmitResult(context.Context, *SubmitResultRequest) (*SubmitResultResponse, error)
+	// Heartbeat продлевает аренду задач, которые агент сейчас выполняет
+	Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error)
+	mustEmbedUnimplementedCalculatorServer()
+}
+
+// UnimplementedCalculatorServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedCalculatorServer struct{}
+
+func (UnimplementedCalculatorServer) Calculate(context.Context, *CalculationRequest) (*CalculationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Calculate not implemented")
+}
+func (UnimplementedCalculatorServer) CreateExpression(context.Context, *ExpressionRequest) (*ExpressionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateExpression not implemented")
+}
+func (UnimplementedCalculatorServer) GetExpressions(context.Context, *GetExpressionsRequest) (*GetExpressionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetExpressions not implemented")
+}
+func (UnimplementedCalculatorServer) GetTask(context.Context, *GetTaskRequest) (*GetTaskResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTask not implemented")
+}
+func (UnimplementedCalculatorServer) StreamTasks(grpc.BidiStreamingServer[TaskSlots, Task]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamTasks not implemented")
+}
+func (UnimplementedCalculatorServer) SubmitResult(context.Context, *SubmitResultRequest) (*SubmitResultResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SubmitResult not implemented")
+}
+func (UnimplementedCalculatorServer) Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Heartbeat not implemented")
+}
+func (UnimplementedCalculatorServer) mustEmbedUnimplementedCalculatorServer() {}
+func (UnimplementedCalculatorServer) testEmbeddedByValue()                    {}
+
+// UnsafeCalculatorServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to CalculatorServer will
+// result in compilation errors.
+type UnsafeCalculatorServer interface {
+	mustEmbedUnimplementedCalculatorServer()
+}
+
+func RegisterCalculatorServer(s grpc.ServiceRegistrar, srv CalculatorServer) {
+	// If the following call pancis, it indicates UnimplementedCalculatorServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Calculator_ServiceDesc, srv)
+}
+
+func _Calculator_Calculate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CalculationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CalculatorServer).Calculate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Calculator_Calculate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CalculatorServer).Calculate(ctx, req.(*CalculationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Calculator_CreateExpression_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExpressionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CalculatorServer).CreateExpression(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Calculator_CreateExpression_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CalculatorServer).CreateExpression(ctx, req.(*ExpressionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Calculator_GetExpressions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetExpressionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CalculatorServer).GetExpressions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Calculator_GetExpressions_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CalculatorServer).GetExpressions(ctx, req.(*GetExpressionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Calculator_GetTask_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetTaskRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CalculatorServer).GetTask(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Calculator_GetTask_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CalculatorServer).GetTask(ctx, req.(*GetTaskRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Calculator_StreamTasks_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(CalculatorServer).StreamTasks(&grpc.GenericServerStream[TaskSlots, Task]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Calculator_StreamTasksServer = grpc.BidiStreamingServer[TaskSlots, Task]
+
+func _Calculator_SubmitResult_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubmitResultRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CalculatorServer).SubmitResult(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Calculator_SubmitResult_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CalculatorServer).SubmitResult(ctx, req.(*SubmitResultRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Calculator_Heartbeat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HeartbeatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CalculatorServer).Heartbeat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Calculator_Heartbeat_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CalculatorServer).Heartbeat(ctx, req.(*HeartbeatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Calculator_ServiceDesc is the grpc.ServiceDesc for Calculator service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Calculator_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "calculator.Calculator",
+	HandlerType: (*CalculatorServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Calculate",
+			Handler:    _Calculator_Calculate_Handler,
+		},
+		{
+			MethodName: "CreateExpression",
+			Handler:    _Calculator_CreateExpression_Handler,
+		},
+		{
+			MethodName: "GetExpressions",
+			Handler:    _Calculator_GetExpressions_Handler,
+		},
+		{
+			MethodName: "GetTask",
+			Handler:    _Calculator_GetTask_Handler,
+		},
+		{
+			MethodName: "SubmitResult",
+			Handler:    _Calculator_SubmitResult_Handler,
+		},
+		{
+			MethodName: "Heartbeat",
+			Handler:    _Calculator_Heartbeat_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamTasks",
+			Handler:       _Calculator_StreamTasks_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "calculator.proto",
+}
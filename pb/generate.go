@@ -0,0 +1,4 @@
+// Package pb содержит gRPC-контракт сервиса, сгенерированный из calculator.proto
+package pb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative calculator.proto
@@ -2,123 +2,171 @@ package server
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net"
 
 	"github.com/m1tka051209/calculator-service/calculator"
 	"github.com/m1tka051209/calculator-service/db"
 	"github.com/m1tka051209/calculator-service/models"
+	"github.com/m1tka051209/calculator-service/pb"
+	"github.com/m1tka051209/calculator-service/task_manager"
+	"github.com/m1tka051209/calculator-service/webhook"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-// CalculatorClient интерфейс для клиента
-type CalculatorClient interface {
-	Calculate(ctx context.Context, in *CalculationRequest, opts ...grpc.CallOption) (*CalculationResponse, error)
-}
-
-// calculatorClient реализация клиента
-type calculatorClient struct {
-	cc grpc.ClientConnInterface
-}
-
-// NewCalculatorClient создает нового клиента
-func NewCalculatorClient(cc grpc.ClientConnInterface) CalculatorClient {
-	return &calculatorClient{cc}
-}
-
-func (c *calculatorClient) Calculate(ctx context.Context, in *CalculationRequest, opts ...grpc.CallOption) (*CalculationResponse, error) {
-	out := new(CalculationResponse)
-	err := c.cc.Invoke(ctx, "/calculator.Calculator/Calculate", in, out, opts...)
-	if err != nil {
-		return nil, err
-	}
-	return out, nil
-}
-
 // CalculatorServer реализует gRPC сервис
 type CalculatorServer struct {
+	pb.UnimplementedCalculatorServer
 	repo db.Repository
+	tm   task_manager.TaskManagerInterface
 }
 
-// CalculationRequest запрос на вычисление
-type CalculationRequest struct {
-	Arg1      float64
-	Arg2      float64
-	Operation string
+// NewCalculatorServer создает сервер поверх репозитория и менеджера задач
+func NewCalculatorServer(repo db.Repository, tm task_manager.TaskManagerInterface) *CalculatorServer {
+	return &CalculatorServer{repo: repo, tm: tm}
 }
 
-// CalculationResponse ответ с результатом
-type CalculationResponse struct {
-	Result float64
-}
-
-// ExpressionRequest запрос на создание выражения
-type ExpressionRequest struct {
-	UserID     string
-	Expression string
-}
-
-// ExpressionResponse ответ с ID выражения
-type ExpressionResponse struct {
-	ExpressionID string
-}
-
-// GetExpressionsRequest запрос на получение выражений
-type GetExpressionsRequest struct {
-	UserID string
-}
-
-// GetExpressionsResponse ответ со списком выражений
-type GetExpressionsResponse struct {
-	Expressions []models.Expression
+// NewGRPCServer создает gRPC сервер с сервисом калькулятора, который принимает
+// только вызовы с токеном агента agentToken
+func NewGRPCServer(agentToken string, repo db.Repository, tm task_manager.TaskManagerInterface) *grpc.Server {
+	auth := agentAuth{token: agentToken}
+	s := grpc.NewServer(grpc.UnaryInterceptor(auth.unary), grpc.StreamInterceptor(auth.stream))
+	pb.RegisterCalculatorServer(s, NewCalculatorServer(repo, tm))
+	return s
 }
 
 // StartGRPCServer запускает gRPC сервер
-func StartGRPCServer(port string, repo db.Repository) error {
+func StartGRPCServer(port, agentToken string, repo db.Repository, tm task_manager.TaskManagerInterface) error {
 	lis, err := net.Listen("tcp", ":"+port)
 	if err != nil {
 		return err
 	}
 
-	s := grpc.NewServer()
-	
-	// Регистрируем сервер напрямую, без генерации кода из .proto
-	RegisterCalculatorServer(s, &CalculatorServer{repo: repo})
-	
+	s := NewGRPCServer(agentToken, repo, tm)
+
 	log.Printf("gRPC server started on port %s", port)
 	return s.Serve(lis)
 }
 
-// RegisterCalculatorServer регистрирует сервер
-func RegisterCalculatorServer(s *grpc.Server, srv *CalculatorServer) {
-}
-
 // Calculate реализует gRPC метод
-func (s *CalculatorServer) Calculate(ctx context.Context, req *CalculationRequest) (*CalculationResponse, error) {
+func (s *CalculatorServer) Calculate(ctx context.Context, req *pb.CalculationRequest) (*pb.CalculationResponse, error) {
 	task := &models.Task{
-		Arg1:      req.Arg1,
-		Arg2:      req.Arg2,
-		Operation: req.Operation,
+		Arg1:          req.Arg1,
+		Arg2:          req.Arg2,
+		Args:          req.Args,
+		Operation:     req.Operation,
+		OperationTime: int(req.OperationTimeMs),
+		Precision:     req.Precision,
+		Scale:         int(req.Scale),
+		Rounding:      req.Rounding,
+		ExactArg1:     req.ExactArg1,
+		ExactArg2:     req.ExactArg2,
+		ExactArgs:     req.ExactArgs,
+	}
+
+	resp := &pb.CalculationResponse{}
+	var err error
+	if calculator.IsExact(task.Precision) {
+		if resp.ExactResult, err = calculator.CalculateExact(ctx, task); err == nil {
+			resp.Result, err = calculator.ApproximateExact(resp.ExactResult)
+		}
+	} else {
+		resp.Result, err = calculator.Calculate(ctx, task)
+	}
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, status.FromContextError(ctxErr).Err()
+		}
+		return nil, status.Error(calculationErrorCode(err), err.Error())
 	}
+	return resp, nil
+}
 
-	result := calculator.Calculate(task)
-	return &CalculationResponse{Result: result}, nil
+// calculationErrorCode сопоставляет ошибку вычисления gRPC-коду.
+// Все эти ошибки детерминированы: повтор с теми же аргументами не поможет.
+func calculationErrorCode(err error) codes.Code {
+	switch {
+	case errors.Is(err, calculator.ErrDivisionByZero), errors.Is(err, calculator.ErrModuloByZero),
+		errors.Is(err, calculator.ErrNaN), errors.Is(err, calculator.ErrDomain),
+		errors.Is(err, calculator.ErrArgumentCount), errors.Is(err, calculator.ErrInvalidPrecision):
+		return codes.InvalidArgument
+	case errors.Is(err, calculator.ErrUnknownOperator):
+		return codes.Unimplemented
+	case errors.Is(err, calculator.ErrOverflow):
+		return codes.OutOfRange
+	default:
+		return codes.Internal
+	}
 }
 
 // CreateExpression создает новое выражение
-func (s *CalculatorServer) CreateExpression(ctx context.Context, req *ExpressionRequest) (*ExpressionResponse, error) {
-	exprID, err := s.repo.CreateExpression(ctx, req.UserID, req.Expression)
+func (s *CalculatorServer) CreateExpression(ctx context.Context, req *pb.ExpressionRequest) (*pb.ExpressionResponse, error) {
+	if req.CallbackUrl != "" {
+		if err := webhook.ValidateURL(ctx, req.CallbackUrl); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	expr := &models.Expression{
+		UserID:      req.UserId,
+		Expression:  req.Expression,
+		CallbackURL: req.CallbackUrl,
+		Precision:   req.Precision,
+		Rounding:    req.Rounding,
+	}
+	if req.Scale != nil {
+		scale := int(*req.Scale)
+		expr.Scale = &scale
+	}
+	exprID, err := s.tm.SubmitExpression(ctx, expr)
+	if errors.Is(err, calculator.ErrInvalidExpression) || errors.Is(err, calculator.ErrInvalidPrecision) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 	if err != nil {
 		return nil, err
 	}
-	return &ExpressionResponse{ExpressionID: exprID}, nil
+	return &pb.ExpressionResponse{ExpressionId: exprID}, nil
 }
 
 // GetExpressions возвращает список выражений пользователя
-func (s *CalculatorServer) GetExpressions(ctx context.Context, req *GetExpressionsRequest) (*GetExpressionsResponse, error) {
-	exprs, err := s.repo.GetExpressionsByUser(ctx, req.UserID)
+func (s *CalculatorServer) GetExpressions(ctx context.Context, req *pb.GetExpressionsRequest) (*pb.GetExpressionsResponse, error) {
+	exprs, err := s.repo.GetExpressionsByUser(ctx, req.UserId)
 	if err != nil {
 		return nil, err
 	}
-	return &GetExpressionsResponse{Expressions: exprs}, nil
-}
\ No newline at end of file
+
+	resp := &pb.GetExpressionsResponse{}
+	for i := range exprs {
+		resp.Expressions = append(resp.Expressions, expressionToProto(&exprs[i]))
+	}
+	return resp, nil
+}
+
+func expressionToProto(e *models.Expression) *pb.Expression {
+	out := &pb.Expression{
+		Id:              e.ID,
+		UserId:          e.UserID,
+		Expression:      e.Expression,
+		Status:          e.Status,
+		Result:          e.GetResult(),
+		Error:           e.Error,
+		CreatedAt:       timestamppb.New(e.CreatedAt),
+		Precision:       e.Precision,
+		ExactResult:     e.ExactResult,
+		DecimalResult:   e.DecimalResult,
+		Variables:       e.Variables,
+		Functions:       e.Functions,
+		EliminatedTasks: int32(e.EliminatedTasks),
+	}
+	if e.StartedAt != nil {
+		out.StartedAt = timestamppb.New(*e.StartedAt)
+	}
+	if e.CompletedAt != nil {
+		out.CompletedAt = timestamppb.New(*e.CompletedAt)
+	}
+	return out
+}
@@ -2,9 +2,23 @@ package server
 
 import (
 	"context"
+	"net"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/m1tka051209/calculator-service/calculator"
+	"github.com/m1tka051209/calculator-service/db"
+	"github.com/m1tka051209/calculator-service/pb"
+	"github.com/m1tka051209/calculator-service/task_manager"
+	"github.com/m1tka051209/calculator-service/worker"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
 	// "github.com/stretchr/testify/mock"
 )
 
@@ -22,18 +36,26 @@ func TestCalculate(t *testing.T) {
 
 	tests := []struct {
 		name     string
-		request  *CalculationRequest
+		request  *pb.CalculationRequest
 		expected float64
 	}{
 		{
 			name: "simple addition",
-			request: &CalculationRequest{
+			request: &pb.CalculationRequest{
 				Arg1:      2,
 				Arg2:      3,
 				Operation: "+",
 			},
 			expected: 5,
 		},
+		{
+			name: "decimal addition",
+			request: &pb.CalculationRequest{
+				Operation: "+", Precision: "decimal", Scale: 2, Rounding: "half_even",
+				ExactArg1: "0.1", ExactArg2: "0.2",
+			},
+			expected: 0.3,
+		},
 	}
 
 	for _, tt := range tests {
@@ -43,4 +65,246 @@ func TestCalculate(t *testing.T) {
 			assert.Equal(t, tt.expected, resp.Result)
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestCalculateErrorCodes(t *testing.T) {
+	server := &CalculatorServer{}
+
+	tests := []struct {
+		name    string
+		request *pb.CalculationRequest
+		code    codes.Code
+	}{
+		{"division by zero", &pb.CalculationRequest{Arg1: 1, Arg2: 0, Operation: "/"}, codes.InvalidArgument},
+		{"unknown operator", &pb.CalculationRequest{Arg1: 1, Arg2: 2, Operation: "?"}, codes.Unimplemented},
+		{"overflow", &pb.CalculationRequest{Arg1: 1e308, Arg2: 1e308, Operation: "*"}, codes.OutOfRange},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := server.Calculate(context.Background(), tt.request)
+			assert.Equal(t, tt.code, status.Code(err))
+		})
+	}
+}
+
+const testAgentToken = "test-agent-token"
+
+// newTestClient поднимает сервер на bufconn и возвращает подключенного к нему клиента
+func newTestClient(t *testing.T) pb.CalculatorClient {
+	t.Helper()
+	return newTestClientWithToken(t, testAgentToken)
+}
+
+// newTestClientWithToken как newTestClient, но клиент передает токен агента token
+func newTestClientWithToken(t *testing.T, token string) pb.CalculatorClient {
+	t.Helper()
+
+	repo, err := db.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { repo.Close() })
+
+	lis := bufconn.Listen(1 << 20)
+	tm := task_manager.NewTaskManager(repo, time.Minute, task_manager.RetryPolicy{MaxAttempts: 2}, nil,
+		calculator.DecimalOptions{Scale: 10, Rounding: calculator.RoundHalfEven}, calculator.Optimizations{})
+	s := NewGRPCServer(testAgentToken, repo, tm)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithPerRPCCredentials(worker.AgentToken(token)))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewCalculatorClient(conn)
+}
+
+func TestAgentToken(t *testing.T) {
+	ctx := context.Background()
+
+	for _, token := range []string{"", "wrong-token"} {
+		client := newTestClientWithToken(t, token)
+		_, err := client.Calculate(ctx, &pb.CalculationRequest{Arg1: 1, Arg2: 2, Operation: "+"})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		_, err = client.GetExpressions(ctx, &pb.GetExpressionsRequest{UserId: "user1"})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+		stream, err := client.StreamTasks(ctx)
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	}
+
+	client := newTestClient(t)
+	_, err := client.Heartbeat(ctx, &pb.HeartbeatRequest{TaskIds: []string{"t1"}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.SubmitResult(ctx, &pb.SubmitResultRequest{TaskId: "t1", Outcome: &pb.SubmitResultRequest_Result{Result: 1}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestCalculatorServiceEndToEnd(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+
+	resp, err := client.Calculate(ctx, &pb.CalculationRequest{Arg1: 6, Arg2: 3, Operation: "/"})
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, resp.Result)
+
+	_, err = client.Calculate(ctx, &pb.CalculationRequest{Arg1: 6, Arg2: 0, Operation: "/"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	created, err := client.CreateExpression(ctx, &pb.ExpressionRequest{UserId: "user1", Expression: "2+2*2"})
+	require.NoError(t, err)
+	assert.NotEmpty(t, created.ExpressionId)
+
+	_, err = client.CreateExpression(ctx, &pb.ExpressionRequest{UserId: "user1", Expression: "2+"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	list, err := client.GetExpressions(ctx, &pb.GetExpressionsRequest{UserId: "user1"})
+	require.NoError(t, err)
+	require.Len(t, list.Expressions, 1)
+	assert.Equal(t, created.ExpressionId, list.Expressions[0].Id)
+	assert.Equal(t, "pending", list.Expressions[0].Status)
+}
+
+func TestTaskDispatch(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+
+	_, err := client.CreateExpression(ctx, &pb.ExpressionRequest{UserId: "user1", Expression: "2+2*2"})
+	require.NoError(t, err)
+
+	// Пока умножение не вычислено, сложение не готово
+	first, err := client.GetTask(ctx, &pb.GetTaskRequest{AgentId: "agent1"})
+	require.NoError(t, err)
+	require.NotNil(t, first.Task)
+	assert.Equal(t, "*", first.Task.Operation)
+	assert.EqualValues(t, 1, first.Task.Attempt)
+
+	none, err := client.GetTask(ctx, &pb.GetTaskRequest{AgentId: "agent2"})
+	require.NoError(t, err)
+	assert.Nil(t, none.Task)
+
+	hb, err := client.Heartbeat(ctx, &pb.HeartbeatRequest{AgentId: "agent1", TaskIds: []string{first.Task.Id, "unknown"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"unknown"}, hb.LostTaskIds)
+
+	_, err = client.SubmitResult(ctx, &pb.SubmitResultRequest{
+		TaskId: first.Task.Id, AgentId: "agent2",
+		Outcome: &pb.SubmitResultRequest_Result{Result: 4},
+	})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	_, err = client.SubmitResult(ctx, &pb.SubmitResultRequest{
+		TaskId: first.Task.Id, AgentId: "agent1",
+		Outcome: &pb.SubmitResultRequest_Result{Result: 4},
+	})
+	require.NoError(t, err)
+
+	second, err := client.GetTask(ctx, &pb.GetTaskRequest{AgentId: "agent1"})
+	require.NoError(t, err)
+	require.NotNil(t, second.Task)
+	assert.Equal(t, "+", second.Task.Operation)
+	assert.Equal(t, 4.0, second.Task.Arg2)
+
+	_, err = client.SubmitResult(ctx, &pb.SubmitResultRequest{
+		TaskId: second.Task.Id, AgentId: "agent1",
+		Outcome: &pb.SubmitResultRequest_Result{Result: 6},
+	})
+	require.NoError(t, err)
+
+	list, err := client.GetExpressions(ctx, &pb.GetExpressionsRequest{UserId: "user1"})
+	require.NoError(t, err)
+	assert.Equal(t, "completed", list.Expressions[0].Status)
+	assert.Equal(t, 6.0, list.Expressions[0].Result)
+}
+
+func TestDecimalTaskDispatch(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+
+	_, err := client.CreateExpression(ctx, &pb.ExpressionRequest{
+		UserId: "user1", Expression: "(0.1+0.2)*3", Precision: "decimal",
+	})
+	require.NoError(t, err)
+
+	for _, want := range []struct{ arg1, arg2, result string }{{"0.1", "0.2", "0.3"}, {"0.3", "3", "0.9"}} {
+		resp, err := client.GetTask(ctx, &pb.GetTaskRequest{AgentId: "agent1"})
+		require.NoError(t, err)
+		require.NotNil(t, resp.Task)
+		assert.Equal(t, "decimal", resp.Task.Precision)
+		assert.Equal(t, want.arg1, resp.Task.ExactArg1)
+		assert.Equal(t, want.arg2, resp.Task.ExactArg2)
+
+		_, err = client.SubmitResult(ctx, &pb.SubmitResultRequest{
+			TaskId: resp.Task.Id, AgentId: "agent1",
+			Outcome: &pb.SubmitResultRequest_ExactResult{ExactResult: want.result},
+		})
+		require.NoError(t, err)
+	}
+
+	list, err := client.GetExpressions(ctx, &pb.GetExpressionsRequest{UserId: "user1"})
+	require.NoError(t, err)
+	assert.Equal(t, "completed", list.Expressions[0].Status)
+	assert.Equal(t, "0.9", list.Expressions[0].ExactResult)
+	assert.Equal(t, 0.9, list.Expressions[0].Result)
+
+	_, err = client.CreateExpression(ctx, &pb.ExpressionRequest{UserId: "user1", Expression: "1", Precision: "quad"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestSubmitPermanentError(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+
+	_, err := client.CreateExpression(ctx, &pb.ExpressionRequest{UserId: "user1", Expression: "1/0"})
+	require.NoError(t, err)
+
+	resp, err := client.GetTask(ctx, &pb.GetTaskRequest{AgentId: "agent1"})
+	require.NoError(t, err)
+	_, err = client.SubmitResult(ctx, &pb.SubmitResultRequest{
+		TaskId: resp.Task.Id, AgentId: "agent1",
+		Outcome: &pb.SubmitResultRequest_Error{Error: &pb.TaskError{Message: "division by zero", Permanent: true}},
+	})
+	require.NoError(t, err)
+
+	list, err := client.GetExpressions(ctx, &pb.GetExpressionsRequest{UserId: "user1"})
+	require.NoError(t, err)
+	assert.Equal(t, "failed", list.Expressions[0].Status)
+	assert.Equal(t, "division by zero", list.Expressions[0].Error)
+}
+
+func TestStreamTasks(t *testing.T) {
+	client := newTestClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.StreamTasks(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.TaskSlots{AgentId: "agent1", FreeSlots: 1}))
+
+	// Задача приходит сразу после создания выражения, без опроса
+	_, err = client.CreateExpression(ctx, &pb.ExpressionRequest{UserId: "user1", Expression: "1+2-3"})
+	require.NoError(t, err)
+
+	task, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "+", task.Operation)
+
+	_, err = client.SubmitResult(ctx, &pb.SubmitResultRequest{
+		TaskId: task.Id, AgentId: "agent1",
+		Outcome: &pb.SubmitResultRequest_Result{Result: 3},
+	})
+	require.NoError(t, err)
+
+	// Освободившийся слот получает следующую ставшую готовой задачу
+	require.NoError(t, stream.Send(&pb.TaskSlots{FreeSlots: 1}))
+	task, err = stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "-", task.Operation)
+	assert.Equal(t, 3.0, task.Arg1)
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/m1tka051209/calculator-service/calculator"
+	"github.com/m1tka051209/calculator-service/db"
+	"github.com/m1tka051209/calculator-service/models"
+	"github.com/m1tka051209/calculator-service/pb"
+	"github.com/m1tka051209/calculator-service/task_manager"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GetTask выдает агенту следующую готовую задачу в аренду
+func (s *CalculatorServer) GetTask(ctx context.Context, req *pb.GetTaskRequest) (*pb.GetTaskResponse, error) {
+	if req.AgentId == "" {
+		return nil, status.Error(codes.InvalidArgument, "agent_id is required")
+	}
+
+	task, err := s.tm.GetNextTask(req.AgentId)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to claim task")
+	}
+	if task == nil {
+		return &pb.GetTaskResponse{}, nil
+	}
+	return &pb.GetTaskResponse{Task: taskToProto(task)}, nil
+}
+
+// SubmitResult сохраняет результат задачи или обрабатывает ошибку ее выполнения
+// по политике повторов
+func (s *CalculatorServer) SubmitResult(ctx context.Context, req *pb.SubmitResultRequest) (*pb.SubmitResultResponse, error) {
+	if req.AgentId == "" {
+		return nil, status.Error(codes.InvalidArgument, "agent_id is required")
+	}
+
+	task, err := s.tm.GetTask(ctx, req.TaskId)
+	if errors.Is(err, db.ErrTaskNotFound) {
+		return nil, status.Error(codes.NotFound, "task not found")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to load task")
+	}
+	// Быстрый отказ; окончательно аренду проверяет сохранение результата, так как
+	// reaper может отобрать задачу уже после этой проверки
+	if task.Status != "processing" || task.WorkerID != req.AgentId {
+		return nil, status.Error(codes.FailedPrecondition, db.ErrLeaseLost.Error())
+	}
+
+	switch outcome := req.Outcome.(type) {
+	case *pb.SubmitResultRequest_Result:
+		err = s.tm.UpdateTaskResult(ctx, task.ID, req.AgentId, outcome.Result)
+	case *pb.SubmitResultRequest_ExactResult:
+		err = s.tm.UpdateTaskExactResult(ctx, task, req.AgentId, outcome.ExactResult)
+		if errors.Is(err, calculator.ErrInvalidPrecision) || errors.Is(err, calculator.ErrOverflow) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	case *pb.SubmitResultRequest_Error:
+		reason := errors.New(outcome.Error.GetMessage())
+		if outcome.Error.GetPermanent() {
+			reason = task_manager.Permanent(reason)
+		}
+		err = s.tm.FailTask(ctx, task, req.AgentId, reason)
+	default:
+		return nil, status.Error(codes.InvalidArgument, "result or error is required")
+	}
+
+	if errors.Is(err, db.ErrLeaseLost) {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to save task outcome")
+	}
+	return &pb.SubmitResultResponse{}, nil
+}
+
+// Heartbeat продлевает аренду задач агента и сообщает, какие из них уже потеряны
+func (s *CalculatorServer) Heartbeat(ctx context.Context, req *pb.HeartbeatRequest) (*pb.HeartbeatResponse, error) {
+	if req.AgentId == "" {
+		return nil, status.Error(codes.InvalidArgument, "agent_id is required")
+	}
+
+	resp := &pb.HeartbeatResponse{}
+	for _, taskID := range req.TaskIds {
+		err := s.tm.RenewLease(ctx, taskID, req.AgentId)
+		if errors.Is(err, db.ErrLeaseLost) {
+			resp.LostTaskIds = append(resp.LostTaskIds, taskID)
+			continue
+		}
+		if err != nil {
+			return nil, status.Error(codes.Internal, "failed to renew lease")
+		}
+	}
+	return resp, nil
+}
+
+func taskToProto(t *models.Task) *pb.Task {
+	return &pb.Task{
+		Id:              t.ID,
+		ExpressionId:    t.ExpressionID,
+		Arg1:            t.Arg1,
+		Arg2:            t.Arg2,
+		Args:            t.Args,
+		Precision:       t.Precision,
+		Scale:           int32(t.Scale),
+		Rounding:        t.Rounding,
+		ExactArg1:       t.ExactArg1,
+		ExactArg2:       t.ExactArg2,
+		ExactArgs:       t.ExactArgs,
+		Operation:       t.Operation,
+		OperationTimeMs: int64(t.OperationTime),
+		Attempt:         int32(t.Attempts),
+	}
+}
+
+// streamRecheckInterval через сколько поток перепроверяет очередь, даже если
+// уведомлений не было (например, задачи добавил другой процесс оркестратора)
+var streamRecheckInterval = 5 * time.Second
+
+// StreamTasks отправляет агенту готовые задачи по мере их появления. Агент
+// выдает слоты сообщениями TaskSlots; на каждую отправленную задачу тратится
+// один слот, без свободных слотов задачи агенту не отправляются.
+func (s *CalculatorServer) StreamTasks(stream pb.Calculator_StreamTasksServer) error {
+	ctx := stream.Context()
+
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	agentID := first.AgentId
+	if agentID == "" {
+		return status.Error(codes.InvalidArgument, "agent_id is required")
+	}
+	free := int(first.FreeSlots)
+
+	slots := make(chan int32)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			msg, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case slots <- msg.FreeSlots:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		var ready <-chan struct{}
+		var recheck <-chan time.Time
+		if free > 0 {
+			ready = s.tm.TasksReady()
+			task, err := s.tm.GetNextTask(agentID)
+			if err != nil {
+				return status.Error(codes.Internal, "failed to claim task")
+			}
+			if task != nil {
+				if err := stream.Send(taskToProto(task)); err != nil {
+					// Задача уже в аренде агента: если он ее не получил, ее вернет reaper
+					return err
+				}
+				free--
+				continue
+			}
+			recheck = time.After(streamRecheckInterval)
+		}
+
+		select {
+		case n := <-slots:
+			free += int(n)
+		case <-ready:
+		case <-recheck:
+		case err := <-recvErr:
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
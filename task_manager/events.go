@@ -0,0 +1,193 @@
+package task_manager
+
+import (
+	"sync"
+	"time"
+
+	"github.com/m1tka051209/calculator-service/models"
+)
+
+// Типы событий
+const (
+	// EventTask изменился статус задачи выражения
+	EventTask = "task"
+	// EventExpression изменился статус выражения
+	EventExpression = "expression"
+)
+
+// eventBuffer сколько событий подписчик может не забрать, прежде чем будет отключен
+const eventBuffer = 64
+
+// Event изменение состояния выражения или одной из его задач
+type Event struct {
+	Type         string    `json:"type"`
+	ExpressionID string    `json:"expression_id"`
+	TaskID       string    `json:"task_id,omitempty"`
+	Operation    string    `json:"operation,omitempty"`
+	Status       string    `json:"status"`
+	Result       *float64  `json:"result,omitempty"` // nil, пока результата нет; 0 передается
+	ExactResult  string    `json:"exact_result,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	Time         time.Time `json:"time"`
+}
+
+// Terminal сообщает, что событие завершает выражение и других по нему не будет
+func (e Event) Terminal() bool {
+	return e.Type == EventExpression && (e.Status == "completed" || e.Status == "failed")
+}
+
+// ExpressionEvent событие с текущим состоянием выражения
+func ExpressionEvent(expr *models.Expression) Event {
+	return Event{
+		Type:         EventExpression,
+		ExpressionID: expr.ID,
+		Status:       expr.Status,
+		Result:       expr.Result,
+		ExactResult:  expr.ExactResult,
+		Error:        expr.Error,
+		Time:         time.Now(),
+	}
+}
+
+// Subscription поток событий подписчика. Канал C закрывается после Close или
+// если подписчик не успевает забирать события: тогда ему нужно перечитать
+// состояние выражения и подписаться заново.
+type Subscription struct {
+	C <-chan Event
+
+	ch  chan Event
+	bus *EventBus
+	key string
+}
+
+// Close отписывается от событий
+func (s *Subscription) Close() {
+	s.bus.remove(s)
+}
+
+// EventBus раздает события подписчикам отдельного выражения или всех выражений пользователя
+type EventBus struct {
+	mu   sync.Mutex
+	subs map[string]map[*Subscription]struct{}
+	// lastStatus последний разосланный статус выражения, чтобы рассылать только переходы.
+	// Хранится только для выражений с подписчиками, иначе копился бы для каждого выражения.
+	lastStatus map[string]string
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subs:       make(map[string]map[*Subscription]struct{}),
+		lastStatus: make(map[string]string),
+	}
+}
+
+func expressionKey(id string) string { return "expression:" + id }
+func userKey(id string) string       { return "user:" + id }
+
+// SubscribeExpression подписывается на события одного выражения
+func (b *EventBus) SubscribeExpression(expressionID string) *Subscription {
+	return b.subscribe(expressionKey(expressionID))
+}
+
+// SubscribeUser подписывается на события всех выражений пользователя
+func (b *EventBus) SubscribeUser(userID string) *Subscription {
+	return b.subscribe(userKey(userID))
+}
+
+func (b *EventBus) subscribe(key string) *Subscription {
+	ch := make(chan Event, eventBuffer)
+	s := &Subscription{C: ch, ch: ch, bus: b, key: key}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs[key] == nil {
+		b.subs[key] = make(map[*Subscription]struct{})
+	}
+	b.subs[key][s] = struct{}{}
+	return s
+}
+
+func (b *EventBus) remove(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.removeLocked(s)
+}
+
+func (b *EventBus) removeLocked(s *Subscription) {
+	subs, ok := b.subs[s.key]
+	if !ok {
+		return
+	}
+	if _, ok := subs[s]; !ok {
+		return
+	}
+	delete(subs, s)
+	if len(subs) == 0 {
+		delete(b.subs, s.key)
+	}
+	if len(b.subs) == 0 {
+		clear(b.lastStatus)
+	}
+	close(s.ch)
+}
+
+// Active сообщает, есть ли хоть один подписчик; без них события можно не собирать
+func (b *EventBus) Active() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs) > 0
+}
+
+// PublishTask рассылает событие задачи подписчикам ее выражения и его владельца
+func (b *EventBus) PublishTask(expr *models.Expression, task *models.Task) {
+	b.publish(expr, Event{
+		Type:         EventTask,
+		ExpressionID: task.ExpressionID,
+		TaskID:       task.ID,
+		Operation:    task.Operation,
+		Status:       task.Status,
+		Result:       task.Result,
+		ExactResult:  task.ExactResult,
+		Error:        task.LastError,
+		Time:         time.Now(),
+	})
+}
+
+// PublishExpression рассылает статус выражения, если он изменился с прошлой рассылки
+func (b *EventBus) PublishExpression(expr *models.Expression) {
+	b.mu.Lock()
+	if len(b.subs[expressionKey(expr.ID)]) == 0 && len(b.subs[userKey(expr.UserID)]) == 0 {
+		// Переход никому не нужен; подписавшийся позже получит текущий статус заново
+		delete(b.lastStatus, expr.ID)
+		b.mu.Unlock()
+		return
+	}
+	if b.lastStatus[expr.ID] == expr.Status {
+		b.mu.Unlock()
+		return
+	}
+	if expr.Status == "completed" || expr.Status == "failed" {
+		delete(b.lastStatus, expr.ID)
+	} else {
+		b.lastStatus[expr.ID] = expr.Status
+	}
+	b.mu.Unlock()
+
+	b.publish(expr, ExpressionEvent(expr))
+}
+
+func (b *EventBus) publish(expr *models.Expression, ev Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, key := range []string{expressionKey(expr.ID), userKey(expr.UserID)} {
+		for s := range b.subs[key] {
+			select {
+			case s.ch <- ev:
+			default:
+				// Подписчик отстал: отключаем его, а не теряем события молча
+				b.removeLocked(s)
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package task_manager
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/m1tka051209/calculator-service/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventBusRoutesAndDeduplicates(t *testing.T) {
+	bus := NewEventBus()
+	byExpr := bus.SubscribeExpression("e1")
+	defer byExpr.Close()
+	byUser := bus.SubscribeUser("u1")
+	defer byUser.Close()
+	other := bus.SubscribeExpression("e2")
+	defer other.Close()
+
+	expr := &models.Expression{ID: "e1", UserID: "u1", Status: "processing"}
+	result := 4.0
+	bus.PublishTask(expr, &models.Task{ID: "t1", ExpressionID: "e1", Status: "completed", Result: &result})
+	bus.PublishExpression(expr)
+	bus.PublishExpression(expr)
+	expr.Status = "completed"
+	bus.PublishExpression(expr)
+
+	for _, sub := range []*Subscription{byExpr, byUser} {
+		require.Len(t, sub.C, 3)
+		ev := <-sub.C
+		assert.Equal(t, EventTask, ev.Type)
+		assert.Equal(t, "t1", ev.TaskID)
+		require.NotNil(t, ev.Result)
+		assert.Equal(t, 4.0, *ev.Result)
+		assert.Equal(t, "processing", (<-sub.C).Status)
+		last := <-sub.C
+		assert.Equal(t, "completed", last.Status)
+		assert.True(t, last.Terminal())
+	}
+	assert.Empty(t, other.C)
+}
+
+func TestEventZeroResult(t *testing.T) {
+	zero := 0.0
+	data, err := json.Marshal(ExpressionEvent(&models.Expression{ID: "e1", Status: "completed", Result: &zero}))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"result":0`)
+
+	data, err = json.Marshal(ExpressionEvent(&models.Expression{ID: "e1", Status: "processing"}))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), `"result"`)
+}
+
+func TestEventBusForgetsUnwatchedExpressions(t *testing.T) {
+	bus := NewEventBus()
+	other := bus.SubscribeExpression("watched")
+
+	// Выражения без подписчиков не оставляют записей, даже если их завершение не публикуется
+	for _, id := range []string{"e1", "e2", "e3"} {
+		bus.PublishExpression(&models.Expression{ID: id, UserID: "u1", Status: "pending"})
+	}
+	bus.mu.Lock()
+	assert.Empty(t, bus.lastStatus)
+	bus.mu.Unlock()
+
+	bus.PublishExpression(&models.Expression{ID: "watched", UserID: "u1", Status: "processing"})
+	require.Len(t, other.C, 1)
+	other.Close()
+	bus.mu.Lock()
+	assert.Empty(t, bus.lastStatus, "statuses must be dropped with the last subscriber")
+	bus.mu.Unlock()
+}
+
+func TestEventBusDropsSlowSubscriber(t *testing.T) {
+	bus := NewEventBus()
+	sub := bus.SubscribeExpression("e1")
+	defer sub.Close()
+
+	expr := &models.Expression{ID: "e1", UserID: "u1"}
+	for i := 0; i <= eventBuffer; i++ {
+		bus.PublishTask(expr, &models.Task{ExpressionID: "e1"})
+	}
+
+	n := 0
+	for range sub.C {
+		n++
+	}
+	assert.Equal(t, eventBuffer, n)
+	assert.False(t, bus.Active())
+}
@@ -3,48 +3,302 @@ package task_manager
 import (
 	"context"
 	"log"
+	"sync"
 	"time"
 
+	"github.com/m1tka051209/calculator-service/calculator"
 	"github.com/m1tka051209/calculator-service/db"
 	"github.com/m1tka051209/calculator-service/models"
 )
 
 // TaskManagerInterface определяет интерфейс менеджера задач
 type TaskManagerInterface interface {
-	GetNextTask() (*models.Task, error)
-	UpdateTaskStatus(ctx context.Context, taskID, status string) error
-	UpdateTaskResult(ctx context.Context, taskID string, result float64) error
+	SubmitExpression(ctx context.Context, expr *models.Expression) (string, error)
+	CreateExpression(ctx context.Context, expr *models.Expression, tasks []models.Task) (string, error)
+	TasksReady() <-chan struct{}
+	GetNextTask(workerID string) (*models.Task, error)
+	GetTask(ctx context.Context, taskID string) (*models.Task, error)
+	RenewLease(ctx context.Context, taskID, workerID string) error
+	UpdateTaskResult(ctx context.Context, taskID, workerID string, result float64) error
+	UpdateTaskExactResult(ctx context.Context, task *models.Task, workerID, exact string) error
+	FailTask(ctx context.Context, task *models.Task, workerID string, reason error) error
+	RequeueDeadTask(ctx context.Context, taskID string) error
+	Events() *EventBus
 }
 
 type TaskManager struct {
-	repo db.Repository
+	repo   db.Repository
+	lease  time.Duration
+	retry  RetryPolicy
+	notify *Notifier
+	// opTimes время выполнения операций, проставляемое задачам при создании
+	opTimes calculator.OperationTimes
+	// decimal масштаб и округление выражений decimal, если они их не задали
+	decimal calculator.DecimalOptions
+	// optimize упрощения выражений перед разложением на задачи
+	optimize calculator.Optimizations
+	events   *EventBus
+	// publishMu упорядочивает чтение состояния и рассылку, чтобы подписчики
+	// не получили устаревший статус после более нового
+	publishMu sync.Mutex
 }
 
-func NewTaskManager(repo db.Repository) TaskManagerInterface {
-	return &TaskManager{repo: repo}
+func NewTaskManager(repo db.Repository, lease time.Duration, retry RetryPolicy, opTimes calculator.OperationTimes,
+	decimal calculator.DecimalOptions, optimize calculator.Optimizations) *TaskManager {
+	return &TaskManager{
+		repo:     repo,
+		lease:    lease,
+		retry:    retry,
+		notify:   NewNotifier(),
+		opTimes:  opTimes,
+		decimal:  decimal,
+		optimize: optimize,
+		events:   NewEventBus(),
+	}
+}
+
+// Events возвращает шину событий о ходе вычисления выражений
+func (tm *TaskManager) Events() *EventBus {
+	return tm.events
+}
+
+// publishTask рассылает новое состояние задачи и, если он изменился, статус ее выражения
+func (tm *TaskManager) publishTask(ctx context.Context, taskID string) {
+	if !tm.events.Active() {
+		return
+	}
+	tm.publishMu.Lock()
+	defer tm.publishMu.Unlock()
+
+	task, err := tm.repo.GetTask(ctx, taskID)
+	if err != nil {
+		log.Printf("Error loading task %s for events: %v", taskID, err)
+		return
+	}
+	expr, err := tm.repo.GetExpression(ctx, task.ExpressionID)
+	if err != nil {
+		log.Printf("Error loading expression %s for events: %v", task.ExpressionID, err)
+		return
+	}
+	tm.events.PublishTask(expr, task)
+	tm.events.PublishExpression(expr)
+}
+
+// SubmitExpression разбирает expr.Expression, раскладывает его на задачи и ставит
+// их в очередь; остальные поля expr (пользователь, callback URL) сохраняются как есть,
+// незаданные масштаб и округление режима decimal берутся по умолчанию.
+// Имена в выражении заменяются значениями констант и переменных пользователя,
+// вызовы его функций - их телами; использованные значения и определения
+// записываются в expr.Variables и expr.Functions. Включенные упрощения применяются
+// до разложения, число убранных ими задач - в expr.EliminatedTasks. Ошибка разбора, неизвестное
+// имя или рекурсивный вызов оборачивает calculator.ErrInvalidExpression, неверный режим
+// точности - calculator.ErrInvalidPrecision.
+func (tm *TaskManager) SubmitExpression(ctx context.Context, expr *models.Expression) (string, error) {
+	if err := calculator.ApplyPrecision(expr, tm.decimal); err != nil {
+		return "", err
+	}
+	exact := calculator.IsExact(expr.Precision)
+
+	vars, err := tm.repo.GetVariables(ctx, expr.UserID)
+	if err != nil {
+		return "", err
+	}
+	fns, err := tm.repo.GetFunctions(ctx, expr.UserID)
+	if err != nil {
+		return "", err
+	}
+	opts := calculator.Options{
+		Precision: expr.Precision,
+		Rounding:  expr.Rounding,
+		Optimize:  tm.optimize,
+		Variables: make(map[string]float64, len(vars)),
+		Functions: make(map[string]models.UserFunction, len(fns)),
+	}
+	if exact {
+		opts.Scale = *expr.Scale
+	}
+	for _, v := range vars {
+		opts.Variables[v.Name] = v.Value
+	}
+	for _, fn := range fns {
+		opts.Functions[fn.Name] = fn
+	}
+	plan, err := calculator.DecomposeWith(expr.Expression, opts)
+	if err != nil {
+		return "", err
+	}
+	expr.Variables = plan.Variables
+	expr.Functions = plan.Functions
+	expr.EliminatedTasks = plan.Eliminated
+
+	if exact {
+		for i := range plan.Tasks {
+			plan.Tasks[i].Precision = expr.Precision
+			plan.Tasks[i].Scale = *expr.Scale
+			plan.Tasks[i].Rounding = expr.Rounding
+		}
+	}
+	if len(plan.Tasks) == 0 {
+		expr.Status = "completed"
+		expr.Result = &plan.Result
+		if exact {
+			expr.ExactResult, expr.DecimalResult, _, err = calculator.RenderExact(
+				plan.ExactResult, expr.Precision, *expr.Scale, expr.Rounding)
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+	return tm.CreateExpression(ctx, expr, plan.Tasks)
+}
+
+// CreateExpression проставляет задачам время выполнения операций, сохраняет
+// выражение с задачами и будит ожидающих задачи агентов
+func (tm *TaskManager) CreateExpression(ctx context.Context, expr *models.Expression, tasks []models.Task) (string, error) {
+	tm.opTimes.Apply(tasks)
+	id, err := tm.repo.CreateExpression(ctx, expr, tasks)
+	if err != nil {
+		return "", err
+	}
+	tm.publishMu.Lock()
+	tm.events.PublishExpression(expr)
+	tm.publishMu.Unlock()
+	if len(tasks) > 0 {
+		tm.notify.Notify()
+	}
+	return id, nil
+}
+
+// TasksReady возвращает канал, который закроется, когда могут появиться новые готовые
+// задачи. Канал нужно получить до GetNextTask, чтобы не пропустить уведомление.
+func (tm *TaskManager) TasksReady() <-chan struct{} {
+	return tm.notify.Wait()
 }
 
-func (tm *TaskManager) GetNextTask() (*models.Task, error) {
+// GetNextTask забирает следующую готовую задачу в аренду воркеру workerID
+func (tm *TaskManager) GetNextTask(workerID string) (*models.Task, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	tasks, err := tm.repo.GetPendingTasks(ctx, 1)
+	task, err := tm.repo.ClaimTask(ctx, workerID, tm.lease)
 	if err != nil {
-		log.Printf("Error getting tasks: %v", err)
+		log.Printf("Error claiming task: %v", err)
 		return nil, err
 	}
+	if task != nil {
+		tm.publishTask(ctx, task.ID)
+	}
+
+	return task, nil
+}
+
+// RenewLease продлевает аренду задачи еще на LeaseDuration
+func (tm *TaskManager) RenewLease(ctx context.Context, taskID, workerID string) error {
+	return tm.repo.RenewLease(ctx, taskID, workerID, tm.lease)
+}
+
+func (tm *TaskManager) GetTask(ctx context.Context, taskID string) (*models.Task, error) {
+	return tm.repo.GetTask(ctx, taskID)
+}
 
-	if len(tasks) == 0 {
-		return nil, nil
+// UpdateTaskResult сохраняет результат задачи воркера workerID; зависящие от нее задачи
+// могут стать готовыми. Если задача уже не в его аренде, возвращается db.ErrLeaseLost.
+func (tm *TaskManager) UpdateTaskResult(ctx context.Context, taskID, workerID string, result float64) error {
+	if err := tm.repo.UpdateTaskResult(ctx, taskID, workerID, result); err != nil {
+		return err
 	}
+	tm.notify.Notify()
+	tm.publishTask(ctx, taskID)
+	return nil
+}
 
-	return &tasks[0], nil
+// UpdateTaskExactResult как UpdateTaskResult для задачи точного режима: точный
+// результат приводится к виду режима задачи, а его приближения сохраняются рядом.
+// Неразборчивый результат оборачивает calculator.ErrInvalidPrecision.
+func (tm *TaskManager) UpdateTaskExactResult(ctx context.Context, task *models.Task, workerID, exact string) error {
+	exact, decimal, result, err := calculator.RenderExact(exact, task.Precision, task.Scale, task.Rounding)
+	if err != nil {
+		return err
+	}
+	if err := tm.repo.UpdateTaskExactResult(ctx, task.ID, workerID, result, exact, decimal); err != nil {
+		return err
+	}
+	tm.notify.Notify()
+	tm.publishTask(ctx, task.ID)
+	return nil
 }
 
-func (tm *TaskManager) UpdateTaskStatus(ctx context.Context, taskID, status string) error {
-	return tm.repo.UpdateTaskStatus(ctx, taskID, status)
+// FailTask обрабатывает неудачную попытку выполнения задачи воркером workerID и рассылает
+// ее новое состояние
+func (tm *TaskManager) FailTask(ctx context.Context, task *models.Task, workerID string, reason error) error {
+	if err := tm.failTask(ctx, task, workerID, reason); err != nil {
+		return err
+	}
+	tm.publishTask(ctx, task.ID)
+	return nil
 }
 
-func (tm *TaskManager) UpdateTaskResult(ctx context.Context, taskID string, result float64) error {
-	return tm.repo.UpdateTaskResult(ctx, taskID, result)
-}
\ No newline at end of file
+// failTask обрабатывает неудачную попытку выполнения задачи: окончательную ошибку
+// (см. Permanent) сохраняет сразу, иначе возвращает задачу в очередь с задержкой
+// по политике повторов или, если попытки исчерпаны, отправляет в dead letter
+func (tm *TaskManager) failTask(ctx context.Context, task *models.Task, workerID string, reason error) error {
+	if IsPermanent(reason) {
+		log.Printf("Task %s failed permanently: %v", task.ID, reason)
+		return tm.repo.FailTask(ctx, task.ID, workerID, reason.Error())
+	}
+
+	if task.Attempts >= tm.retry.MaxAttempts {
+		log.Printf("Task %s failed after %d attempts, moving to dead letter: %v", task.ID, task.Attempts, reason)
+		return tm.repo.DeadLetterTask(ctx, task.ID, workerID, reason.Error())
+	}
+
+	delay := tm.retry.Backoff(task.Attempts)
+	log.Printf("Task %s attempt %d failed, retrying in %s: %v", task.ID, task.Attempts, delay, reason)
+	if err := tm.repo.RetryTask(ctx, task.ID, workerID, reason.Error(), time.Now().Add(delay)); err != nil {
+		return err
+	}
+	time.AfterFunc(delay, tm.notify.Notify)
+	return nil
+}
+
+// RequeueDeadTask возвращает задачу из dead letter в очередь вместе с остальными
+// задачами ее выражения, которые не дали бы ему завершиться (см. db.Repository.RequeueDeadTask)
+func (tm *TaskManager) RequeueDeadTask(ctx context.Context, taskID string) error {
+	requeued, err := tm.repo.RequeueDeadTask(ctx, taskID)
+	if err != nil {
+		return err
+	}
+	tm.notify.Notify()
+	for _, id := range requeued {
+		tm.publishTask(ctx, id)
+	}
+	return nil
+}
+
+// RunReaper периодически возвращает в очередь задачи с истекшей арендой, а
+// исчерпавшие попытки отправляет в dead letter, пока не отменен ctx
+func (tm *TaskManager) RunReaper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, dead, err := tm.repo.ReclaimExpiredTasks(ctx, tm.retry.MaxAttempts)
+			if err != nil {
+				log.Printf("Error reclaiming expired tasks: %v", err)
+				continue
+			}
+			if n > 0 {
+				log.Printf("Reclaimed %d tasks with expired lease", n)
+				tm.notify.Notify()
+			}
+			for _, taskID := range dead {
+				log.Printf("Task %s lease expired after %d attempts, moving to dead letter", taskID, tm.retry.MaxAttempts)
+				tm.publishTask(ctx, taskID)
+			}
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package task_manager
+
+import "sync"
+
+// Notifier сообщает ожидающим, что в очереди могли появиться готовые задачи.
+// Ожидающий берет канал через Wait до проверки очереди, чтобы не пропустить
+// уведомление, пришедшее между проверкой и ожиданием.
+type Notifier struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+func NewNotifier() *Notifier {
+	return &Notifier{ch: make(chan struct{})}
+}
+
+// Wait возвращает канал, который закроется при следующем Notify
+func (n *Notifier) Wait() <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.ch
+}
+
+// Notify будит всех, кто ждет на каналах, полученных из Wait
+func (n *Notifier) Notify() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	close(n.ch)
+	n.ch = make(chan struct{})
+}
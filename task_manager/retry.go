@@ -0,0 +1,56 @@
+package task_manager
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// permanentError ошибка, при которой повторять задачу бессмысленно
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent помечает ошибку как окончательную: задача с такой ошибкой не повторяется,
+// а сразу завершается со статусом failed
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// IsPermanent сообщает, помечена ли ошибка через Permanent
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// RetryPolicy политика повторного выполнения упавших задач
+type RetryPolicy struct {
+	// MaxAttempts сколько раз задачу можно взять в работу, прежде чем она уйдет в dead letter
+	MaxAttempts int
+	// BaseDelay задержка перед второй попыткой, дальше она удваивается
+	BaseDelay time.Duration
+	// MaxDelay верхняя граница задержки
+	MaxDelay time.Duration
+}
+
+// Backoff возвращает задержку перед следующей попыткой после attempt неудачных:
+// экспоненциальный рост с разбросом в половину задержки, чтобы упавшие вместе
+// задачи не возвращались в очередь одновременно
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
@@ -0,0 +1,234 @@
+// Package webhook отправляет завершенные выражения на callback URL, указанный при их создании
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/m1tka051209/calculator-service/db"
+	"github.com/m1tka051209/calculator-service/models"
+	"github.com/m1tka051209/calculator-service/task_manager"
+)
+
+const (
+	// SignatureHeader заголовок с HMAC-SHA256 тела запроса: "sha256=<hex>"
+	SignatureHeader = "X-Calculator-Signature"
+	// DeliveryHeader заголовок с ID доставки; одинаков во всех ее попытках
+	DeliveryHeader = "X-Calculator-Delivery"
+
+	// batchSize сколько доставок обрабатывается за один проход
+	batchSize = 20
+	// requestTimeout сколько ждать ответа получателя
+	requestTimeout = 10 * time.Second
+)
+
+// errInternalAddress адрес получателя во внутренней сети
+var errInternalAddress = errors.New("callback address is not publicly routable")
+
+// internalPrefixes диапазоны, не покрытые методами netip.Addr: "this network" и
+// общее адресное пространство провайдеров, где живут и метаданные некоторых облаков
+var internalPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+}
+
+// internal сообщает, что адрес не должен получать вебхуки: loopback, частные
+// сети (RFC 1918, fc00::/7), link-local вместе с метаданными облаков 169.254.169.254
+// и подобные им
+func internal(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified() {
+		return true
+	}
+	for _, prefix := range internalPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateURL проверяет, что callback URL абсолютный http(s) адрес, все адреса
+// хоста которого публичные. Адрес проверяется еще раз при каждой доставке, так как
+// DNS может начать отвечать иначе.
+func ValidateURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid callback_url: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("invalid callback_url: must be an absolute http or https URL")
+	}
+
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil {
+		return fmt.Errorf("invalid callback_url: cannot resolve host %q", u.Hostname())
+	}
+	for _, addr := range addrs {
+		if internal(addr) {
+			return fmt.Errorf("invalid callback_url: %w", errInternalAddress)
+		}
+	}
+	return nil
+}
+
+// newClient HTTP клиент доставки: соединяется только с адресами, для которых
+// blocked возвращает false, не ходит через прокси и не следует редиректам, иначе
+// получатель мог бы перенаправить вебхук во внутреннюю сеть
+func newClient(blocked func(netip.Addr) bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: requestTimeout,
+		// Control вызывается для уже разрешенного адреса перед каждым соединением
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if blocked(addrPort.Addr()) {
+				return fmt.Errorf("dial %s: %w", address, errInternalAddress)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   requestTimeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Sign возвращает значение SignatureHeader для тела body.
+// Получатель проверяет его, вычисляя HMAC-SHA256 тела тем же секретом.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher доставляет выражения из очереди webhook_deliveries, повторяя
+// неудачные попытки с задержкой по политике повторов
+type Dispatcher struct {
+	repo   db.Repository
+	secret []byte
+	retry  task_manager.RetryPolicy
+	client *http.Client
+}
+
+func NewDispatcher(repo db.Repository, secret string, retry task_manager.RetryPolicy) *Dispatcher {
+	return &Dispatcher{
+		repo:   repo,
+		secret: []byte(secret),
+		retry:  retry,
+		client: newClient(internal),
+	}
+}
+
+// Run проверяет очередь доставок каждые interval, пока не отменен ctx
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.dispatchDue(ctx)
+		}
+	}
+}
+
+func (d *Dispatcher) dispatchDue(ctx context.Context) {
+	deliveries, err := d.repo.GetDueWebhooks(ctx, batchSize)
+	if err != nil {
+		log.Printf("Error loading webhook deliveries: %v", err)
+		return
+	}
+	for i := range deliveries {
+		if ctx.Err() != nil {
+			return
+		}
+		d.deliver(ctx, &deliveries[i])
+	}
+}
+
+// deliver выполняет одну попытку доставки и сохраняет ее результат
+func (d *Dispatcher) deliver(ctx context.Context, delivery *models.WebhookDelivery) {
+	attempt := &models.WebhookAttempt{DeliveryID: delivery.ID, Attempt: delivery.Attempts + 1}
+	attempt.StatusCode, attempt.Error = d.post(ctx, delivery)
+	if ctx.Err() != nil {
+		// Остановка сервиса: попытка не считается, доставка останется в очереди
+		return
+	}
+
+	status := "delivered"
+	var next *time.Time
+	if attempt.Error != "" {
+		if attempt.Attempt >= d.retry.MaxAttempts {
+			status = "failed"
+			log.Printf("Webhook %s for expression %s failed after %d attempts: %s",
+				delivery.ID, delivery.ExpressionID, attempt.Attempt, attempt.Error)
+		} else {
+			status = "pending"
+			at := time.Now().Add(d.retry.Backoff(attempt.Attempt))
+			next = &at
+		}
+	}
+
+	if err := d.repo.RecordWebhookAttempt(ctx, attempt, status, next); err != nil {
+		log.Printf("Error recording webhook attempt %s: %v", delivery.ID, err)
+	}
+}
+
+// post отправляет выражение получателю и возвращает код ответа и описание ошибки,
+// если доставка не удалась
+func (d *Dispatcher) post(ctx context.Context, delivery *models.WebhookDelivery) (int, string) {
+	expr, err := d.repo.GetExpression(ctx, delivery.ExpressionID)
+	if err != nil {
+		return 0, err.Error()
+	}
+	body, err := json.Marshal(expr)
+	if err != nil {
+		return 0, err.Error()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err.Error()
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(d.secret, body))
+	req.Header.Set(DeliveryHeader, delivery.ID)
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, "unexpected response status " + resp.Status
+	}
+	return resp.StatusCode, ""
+}
@@ -0,0 +1,211 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m1tka051209/calculator-service/calculator"
+	"github.com/m1tka051209/calculator-service/db"
+	"github.com/m1tka051209/calculator-service/models"
+	"github.com/m1tka051209/calculator-service/task_manager"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSecret = "test-secret"
+
+func newTestRepository(t *testing.T) *db.SQLiteRepository {
+	t.Helper()
+	repo, err := db.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { repo.Close() })
+	return repo
+}
+
+// newTestDispatcher диспетчер, которому можно доставлять на httptest сервер по 127.0.0.1
+func newTestDispatcher(repo db.Repository, retry task_manager.RetryPolicy) *Dispatcher {
+	d := NewDispatcher(repo, testSecret, retry)
+	d.client = newClient(func(netip.Addr) bool { return false })
+	return d
+}
+
+// receiver принимает вебхуки, отвечая кодами из statuses по очереди
+type receiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	received []models.Expression
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	t.Helper()
+	rc := &receiver{statuses: statuses}
+	rc.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Equal(t, Sign([]byte(testSecret), body), r.Header.Get(SignatureHeader))
+		assert.NotEmpty(t, r.Header.Get(DeliveryHeader))
+
+		var expr models.Expression
+		require.NoError(t, json.Unmarshal(body, &expr))
+
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+		rc.received = append(rc.received, expr)
+		status := http.StatusOK
+		if len(rc.statuses) > 0 {
+			status, rc.statuses = rc.statuses[0], rc.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(rc.Close)
+	return rc
+}
+
+func TestDeliverWithRetry(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+	rc := newReceiver(t, http.StatusInternalServerError)
+
+	plan, err := calculator.Decompose("2*3")
+	require.NoError(t, err)
+	exprID, err := repo.CreateExpression(ctx, &models.Expression{
+		UserID: "user1", Expression: "2*3", CallbackURL: rc.URL,
+	}, plan.Tasks)
+	require.NoError(t, err)
+
+	d := newTestDispatcher(repo, task_manager.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+	d.dispatchDue(ctx)
+	assert.Empty(t, rc.received, "unfinished expression must not be delivered")
+
+	task, err := repo.ClaimTask(ctx, "w1", time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, repo.UpdateTaskResult(ctx, task.ID, "w1", 6))
+
+	d.dispatchDue(ctx)
+	time.Sleep(10 * time.Millisecond)
+	d.dispatchDue(ctx)
+	d.dispatchDue(ctx)
+
+	require.Len(t, rc.received, 2)
+	assert.Equal(t, exprID, rc.received[1].ID)
+	assert.Equal(t, "completed", rc.received[1].Status)
+	require.NotNil(t, rc.received[1].Result)
+	assert.Equal(t, 6.0, *rc.received[1].Result)
+
+	attempts, err := repo.GetWebhookAttempts(ctx, exprID)
+	require.NoError(t, err)
+	require.Len(t, attempts, 2)
+	assert.Equal(t, http.StatusInternalServerError, attempts[0].StatusCode)
+	assert.NotEmpty(t, attempts[0].Error)
+	assert.Equal(t, 2, attempts[1].Attempt)
+	assert.Equal(t, http.StatusOK, attempts[1].StatusCode)
+	assert.Empty(t, attempts[1].Error)
+}
+
+func TestDeliverGivesUp(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+	rc := newReceiver(t, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+
+	result := 42.0
+	exprID, err := repo.CreateExpression(ctx, &models.Expression{
+		UserID: "user1", Expression: "42", Status: "completed", Result: &result, CallbackURL: rc.URL,
+	}, nil)
+	require.NoError(t, err)
+
+	d := newTestDispatcher(repo, task_manager.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+	for i := 0; i < 4; i++ {
+		d.dispatchDue(ctx)
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	assert.Len(t, rc.received, 2)
+	due, err := repo.GetDueWebhooks(ctx, 10)
+	require.NoError(t, err)
+	assert.Empty(t, due)
+
+	attempts, err := repo.GetWebhookAttempts(ctx, exprID)
+	require.NoError(t, err)
+	assert.Len(t, attempts, 2)
+}
+
+func TestDeliverRefusesInternalAddresses(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+	rc := newReceiver(t)
+
+	result := 42.0
+	exprID, err := repo.CreateExpression(ctx, &models.Expression{
+		UserID: "user1", Expression: "42", Status: "completed", Result: &result, CallbackURL: rc.URL,
+	}, nil)
+	require.NoError(t, err)
+
+	// Адрес мог пройти ValidateURL, а потом DNS стал отвечать loopback
+	d := NewDispatcher(repo, testSecret, task_manager.RetryPolicy{MaxAttempts: 1})
+	d.dispatchDue(ctx)
+
+	assert.Empty(t, rc.received)
+	attempts, err := repo.GetWebhookAttempts(ctx, exprID)
+	require.NoError(t, err)
+	require.Len(t, attempts, 1)
+	assert.Contains(t, attempts[0].Error, errInternalAddress.Error())
+}
+
+func TestDeliverDoesNotFollowRedirects(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+	rc := newReceiver(t)
+	redirect := httptest.NewServer(http.RedirectHandler(rc.URL, http.StatusTemporaryRedirect))
+	t.Cleanup(redirect.Close)
+
+	result := 42.0
+	exprID, err := repo.CreateExpression(ctx, &models.Expression{
+		UserID: "user1", Expression: "42", Status: "completed", Result: &result, CallbackURL: redirect.URL,
+	}, nil)
+	require.NoError(t, err)
+
+	d := newTestDispatcher(repo, task_manager.RetryPolicy{MaxAttempts: 1})
+	d.dispatchDue(ctx)
+
+	assert.Empty(t, rc.received)
+	attempts, err := repo.GetWebhookAttempts(ctx, exprID)
+	require.NoError(t, err)
+	require.Len(t, attempts, 1)
+	assert.Equal(t, http.StatusTemporaryRedirect, attempts[0].StatusCode)
+	assert.NotEmpty(t, attempts[0].Error)
+}
+
+func TestValidateURL(t *testing.T) {
+	ctx := context.Background()
+	assert.NoError(t, ValidateURL(ctx, "https://93.184.215.14/hook"))
+	assert.NoError(t, ValidateURL(ctx, "http://[2606:2800:21f:cb07:6820:80da:af6b:8b2c]:8080/hook"))
+	for _, raw := range []string{"example.com/hook", "ftp://example.com", "http://", "::"} {
+		assert.Error(t, ValidateURL(ctx, raw), raw)
+	}
+	for _, raw := range []string{
+		"http://localhost:8080/hook",
+		"http://127.0.0.1/hook",
+		"http://[::1]/hook",
+		"http://10.1.2.3/hook",
+		"http://172.16.0.1/hook",
+		"http://192.168.1.1/hook",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://[fd00:ec2::254]/",
+		"http://100.100.100.200/",
+		"http://0.0.0.0:8080/",
+		"http://[::ffff:127.0.0.1]/",
+	} {
+		err := ValidateURL(ctx, raw)
+		assert.ErrorIs(t, err, errInternalAddress, raw)
+	}
+}
@@ -2,102 +2,337 @@ package worker
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
-	"os"
-	"os/signal"
-	"syscall"
+	"sync"
 	"time"
 
-	"github.com/m1tka051209/calculator-service/db"
-	"github.com/m1tka051209/calculator-service/server"
-	"github.com/m1tka051209/calculator-service/task_manager"
+	"github.com/m1tka051209/calculator-service/calculator"
+	"github.com/m1tka051209/calculator-service/models"
+	"github.com/m1tka051209/calculator-service/pb"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
-// CalculatorClient определяет упрощенный интерфейс клиента
+// CalculatorClient определяет упрощенный интерфейс клиента оркестратора
 type CalculatorClient interface {
-	Calculate(ctx context.Context, req *server.CalculationRequest) (*server.CalculationResponse, error)
+	StreamTasks(ctx context.Context) (pb.Calculator_StreamTasksClient, error)
+	SubmitResult(ctx context.Context, req *pb.SubmitResultRequest) (*pb.SubmitResultResponse, error)
+	Heartbeat(ctx context.Context, req *pb.HeartbeatRequest) (*pb.HeartbeatResponse, error)
 }
 
 // grpcClientWrapper оборачивает gRPC клиент для соответствия интерфейсу
 type grpcClientWrapper struct {
-	client server.CalculatorClient
+	client pb.CalculatorClient
 }
 
-func (w *grpcClientWrapper) Calculate(ctx context.Context, req *server.CalculationRequest) (*server.CalculationResponse, error) {
-	return w.client.Calculate(ctx, req)
+func (w *grpcClientWrapper) StreamTasks(ctx context.Context) (pb.Calculator_StreamTasksClient, error) {
+	return w.client.StreamTasks(ctx)
+}
+
+func (w *grpcClientWrapper) SubmitResult(ctx context.Context, req *pb.SubmitResultRequest) (*pb.SubmitResultResponse, error) {
+	return w.client.SubmitResult(ctx, req)
+}
+
+func (w *grpcClientWrapper) Heartbeat(ctx context.Context, req *pb.HeartbeatRequest) (*pb.HeartbeatResponse, error) {
+	return w.client.Heartbeat(ctx, req)
 }
 
 func NewCalculatorClient(conn *grpc.ClientConn) CalculatorClient {
 	return &grpcClientWrapper{
-		client: server.NewCalculatorClient(conn),
+		client: pb.NewCalculatorClient(conn),
+	}
+}
+
+// reconnectDelay пауза перед повторным подключением к потоку задач после обрыва
+var reconnectDelay = 1 * time.Second
+
+// Agent пул воркеров, которые получают задачи от оркестратора и отдают ему
+// результаты только через gRPC, поэтому агент может работать на другой машине.
+// Задачи приходят по потоку StreamTasks: агент выдает оркестратору по слоту на
+// каждый свободный воркер и возвращает слот, когда воркер освобождается.
+type Agent struct {
+	id        string
+	client    CalculatorClient
+	heartbeat time.Duration
+
+	mu      sync.Mutex
+	running map[string]context.CancelFunc
+	// stream текущий поток задач, nil пока подключения нет
+	stream pb.Calculator_StreamTasksClient
+	// busy задачи, полученные из потока и еще не завершенные
+	busy int
+}
+
+// NewAgent создает агента; heartbeat должен быть заметно меньше срока аренды задач
+func NewAgent(id string, client CalculatorClient, heartbeat time.Duration) *Agent {
+	return &Agent{
+		id:        id,
+		client:    client,
+		heartbeat: heartbeat,
+		running:   make(map[string]context.CancelFunc),
 	}
 }
 
-func RunWorker(repo db.Repository, workerCount int) {
-	tm := task_manager.NewTaskManager(repo)
+// AgentToken передает токен агента token в метаданных каждого вызова оркестратора
+type AgentToken string
 
-	conn, err := grpc.Dial("localhost:50051", grpc.WithTransportCredentials(insecure.NewCredentials()))
+func (t AgentToken) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+// RequireTransportSecurity разрешает токен без TLS: агенты подключаются к
+// оркестратору во внутренней сети
+func (AgentToken) RequireTransportSecurity() bool {
+	return false
+}
+
+// RunWorker подключается к оркестратору по addr с токеном agentToken и выполняет
+// задачи в workerCount горутинах, пока не отменен ctx
+func RunWorker(ctx context.Context, addr, agentID, agentToken string, workerCount int, heartbeat time.Duration) error {
+	conn, err := grpc.NewClient(addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithPerRPCCredentials(AgentToken(agentToken)))
 	if err != nil {
-		log.Fatalf("Failed to connect to gRPC server: %v", err)
+		return fmt.Errorf("failed to connect to orchestrator: %w", err)
 	}
 	defer conn.Close()
 
-	client := NewCalculatorClient(conn)
+	NewAgent(agentID, NewCalculatorClient(conn), heartbeat).Run(ctx, workerCount)
+	return nil
+}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+// Run запускает воркеры и heartbeat, принимает задачи из потока и после отмены
+// ctx ждет завершения воркеров
+func (a *Agent) Run(ctx context.Context, workerCount int) {
+	// Оркестратор присылает не больше задач, чем свободных слотов,
+	// поэтому буфера на workerCount задач достаточно
+	tasks := make(chan *pb.Task, workerCount)
 
+	var wg sync.WaitGroup
 	for i := 0; i < workerCount; i++ {
-		go processTasks(ctx, tm, client, i)
+		wg.Add(1)
+		go func(workerID string) {
+			defer wg.Done()
+			for task := range tasks {
+				a.processTask(ctx, workerID, task)
+				a.release()
+			}
+			log.Printf("Worker %s shutting down", workerID)
+		}(fmt.Sprintf("%s/worker-%d", a.id, i))
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		a.sendHeartbeats(ctx)
+	}()
+
+	log.Printf("Agent %s started with %d workers", a.id, workerCount)
+	a.receiveTasks(ctx, workerCount, tasks)
+	close(tasks)
+	wg.Wait()
+}
+
+// receiveTasks держит поток задач открытым, переподключаясь после обрывов, пока не отменен ctx
+func (a *Agent) receiveTasks(ctx context.Context, workerCount int, tasks chan<- *pb.Task) {
+	for ctx.Err() == nil {
+		err := a.streamTasks(ctx, workerCount, tasks)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Agent %s task stream closed: %v", a.id, err)
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+func (a *Agent) streamTasks(ctx context.Context, workerCount int, tasks chan<- *pb.Task) error {
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := a.client.StreamTasks(streamCtx)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	err = stream.Send(&pb.TaskSlots{AgentId: a.id, FreeSlots: int32(workerCount - a.busy)})
+	if err == nil {
+		a.stream = stream
+	}
+	a.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		a.mu.Lock()
+		a.stream = nil
+		a.mu.Unlock()
+	}()
+
+	for {
+		task, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+
+		a.mu.Lock()
+		a.busy++
+		a.mu.Unlock()
+		tasks <- task
 	}
+}
+
+// release освобождает слот воркера и возвращает его оркестратору. Если потока
+// сейчас нет, слот будет учтен при следующем подключении.
+func (a *Agent) release() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.busy--
+	if a.stream == nil {
+		return
+	}
+	if err := a.stream.Send(&pb.TaskSlots{FreeSlots: 1}); err != nil {
+		log.Printf("Agent %s error returning slot: %v", a.id, err)
+	}
+}
+
+// processTask вычисляет задачу и отправляет оркестратору результат или ошибку.
+// Пока задача выполняется, ее аренду продлевает sendHeartbeats.
+func (a *Agent) processTask(ctx context.Context, workerID string, task *pb.Task) {
+	calcCtx, cancel := context.WithCancel(ctx)
+	a.track(task.Id, cancel)
+	defer a.untrack(task.Id)
 
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
-	log.Println("Shutting down workers...")
-	cancel()
-	time.Sleep(1 * time.Second)
+	calcTask := &models.Task{
+		ID:            task.Id,
+		Arg1:          task.Arg1,
+		Arg2:          task.Arg2,
+		Args:          task.Args,
+		Operation:     task.Operation,
+		OperationTime: int(task.OperationTimeMs),
+		Precision:     task.Precision,
+		Scale:         int(task.Scale),
+		Rounding:      task.Rounding,
+		ExactArg1:     task.ExactArg1,
+		ExactArg2:     task.ExactArg2,
+		ExactArgs:     task.ExactArgs,
+	}
+	var result float64
+	var exact string
+	var err error
+	if calculator.IsExact(task.Precision) {
+		exact, err = calculator.CalculateExact(calcCtx, calcTask)
+	} else {
+		result, err = calculator.Calculate(calcCtx, calcTask)
+	}
+
+	if calcCtx.Err() != nil {
+		// Агент останавливается или потерял аренду: задачу вернет в очередь reaper
+		log.Printf("Worker %s abandoned task %s", workerID, task.Id)
+		return
+	}
+
+	req := &pb.SubmitResultRequest{TaskId: task.Id, AgentId: a.id}
+	if err != nil {
+		log.Printf("Worker %s calculation error: %v", workerID, err)
+		req.Outcome = &pb.SubmitResultRequest_Error{Error: &pb.TaskError{
+			Message:   err.Error(),
+			Permanent: isPermanent(err),
+		}}
+	} else if exact != "" {
+		req.Outcome = &pb.SubmitResultRequest_ExactResult{ExactResult: exact}
+	} else {
+		req.Outcome = &pb.SubmitResultRequest_Result{Result: result}
+	}
+
+	if _, err := a.client.SubmitResult(ctx, req); err != nil {
+		if status.Code(err) == codes.FailedPrecondition {
+			log.Printf("Worker %s lost lease on task %s", workerID, task.Id)
+		} else {
+			log.Printf("Worker %s error submitting task %s: %v", workerID, task.Id, err)
+		}
+	} else {
+		log.Printf("Worker %s successfully processed task %s", workerID, task.Id)
+	}
 }
 
-func processTasks(ctx context.Context, tm task_manager.TaskManagerInterface, client CalculatorClient, workerID int) {
-	ticker := time.NewTicker(1 * time.Second)
+// isPermanent сообщает, что ошибка вычисления детерминирована и повтор не поможет
+func isPermanent(err error) bool {
+	var calcErr *calculator.CalculationError
+	return errors.As(err, &calcErr)
+}
+
+// sendHeartbeats периодически продлевает аренду выполняемых задач и отменяет
+// те, аренду которых оркестратор уже отобрал
+func (a *Agent) sendHeartbeats(ctx context.Context) {
+	ticker := time.NewTicker(a.heartbeat)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
-			log.Printf("Worker %d shutting down", workerID)
 			return
 		case <-ticker.C:
-			task, err := tm.GetNextTask()
-			if err != nil {
-				log.Printf("Worker %d error getting task: %v", workerID, err)
-				continue
-			}
+		}
+
+		taskIDs := a.runningTasks()
+		if len(taskIDs) == 0 {
+			continue
+		}
 
-			if task == nil {
-				continue
+		resp, err := a.client.Heartbeat(ctx, &pb.HeartbeatRequest{AgentId: a.id, TaskIds: taskIDs})
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Agent %s heartbeat error: %v", a.id, err)
 			}
+			continue
+		}
+		for _, taskID := range resp.LostTaskIds {
+			log.Printf("Agent %s lost lease on task %s", a.id, taskID)
+			a.cancel(taskID)
+		}
+	}
+}
 
-			resp, err := client.Calculate(ctx, &server.CalculationRequest{
-				Arg1:      task.Arg1,
-				Arg2:      task.Arg2,
-				Operation: task.Operation,
-			})
+func (a *Agent) track(taskID string, cancel context.CancelFunc) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.running[taskID] = cancel
+}
 
-			if err != nil {
-				log.Printf("Worker %d calculation error: %v", workerID, err)
-				tm.UpdateTaskStatus(ctx, task.ID, "failed")
-				continue
-			}
+func (a *Agent) untrack(taskID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if cancel, ok := a.running[taskID]; ok {
+		cancel()
+		delete(a.running, taskID)
+	}
+}
 
-			if err := tm.UpdateTaskResult(ctx, task.ID, resp.Result); err != nil {
-				log.Printf("Worker %d error saving result: %v", workerID, err)
-			} else {
-				log.Printf("Worker %d successfully processed task %s", workerID, task.ID)
-			}
-		}
+func (a *Agent) cancel(taskID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if cancel, ok := a.running[taskID]; ok {
+		cancel()
 	}
-}
\ No newline at end of file
+}
+
+func (a *Agent) runningTasks() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ids := make([]string, 0, len(a.running))
+	for id := range a.running {
+		ids = append(ids, id)
+	}
+	return ids
+}
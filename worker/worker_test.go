@@ -2,67 +2,145 @@ package worker
 
 import (
 	"context"
+	"io"
 	"testing"
 	"time"
 
-	"github.com/m1tka051209/calculator-service/server"
-	// "github.com/stretchr/testify/assert"
+	"github.com/m1tka051209/calculator-service/pb"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
-	"github.com/m1tka051209/calculator-service/models"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
 )
 
-type MockTaskManager struct {
+type MockCalculatorClient struct {
 	mock.Mock
 }
 
-func (m *MockTaskManager) GetNextTask() (*models.Task, error) {
-	args := m.Called()
-	return args.Get(0).(*models.Task), args.Error(1)
+func (m *MockCalculatorClient) StreamTasks(ctx context.Context) (pb.Calculator_StreamTasksClient, error) {
+	args := m.Called(ctx)
+	stream := args.Get(0).(*fakeTaskStream)
+	stream.ctx = ctx
+	return stream, args.Error(1)
 }
 
-func (m *MockTaskManager) UpdateTaskStatus(ctx context.Context, taskID, status string) error {
-	args := m.Called(ctx, taskID, status)
-	return args.Error(0)
+func (m *MockCalculatorClient) SubmitResult(ctx context.Context, req *pb.SubmitResultRequest) (*pb.SubmitResultResponse, error) {
+	args := m.Called(ctx, req)
+	return args.Get(0).(*pb.SubmitResultResponse), args.Error(1)
 }
 
-func (m *MockTaskManager) UpdateTaskResult(ctx context.Context, taskID string, result float64) error {
-	args := m.Called(ctx, taskID, result)
-	return args.Error(0)
+func (m *MockCalculatorClient) Heartbeat(ctx context.Context, req *pb.HeartbeatRequest) (*pb.HeartbeatResponse, error) {
+	args := m.Called(ctx, req)
+	return args.Get(0).(*pb.HeartbeatResponse), args.Error(1)
 }
 
-type MockCalculatorClient struct {
-	mock.Mock
+// fakeTaskStream поток задач: отдает задачи из tasks и записывает выданные агентом слоты
+type fakeTaskStream struct {
+	grpc.ClientStream
+	ctx   context.Context
+	tasks chan *pb.Task
+	slots chan *pb.TaskSlots
 }
 
-func (m *MockCalculatorClient) Calculate(ctx context.Context, req *server.CalculationRequest) (*server.CalculationResponse, error) {
-	args := m.Called(ctx, req)
-	return args.Get(0).(*server.CalculationResponse), args.Error(1)
+func newFakeTaskStream(tasks ...*pb.Task) *fakeTaskStream {
+	s := &fakeTaskStream{tasks: make(chan *pb.Task, len(tasks)), slots: make(chan *pb.TaskSlots, 10)}
+	for _, t := range tasks {
+		s.tasks <- t
+	}
+	return s
+}
+
+func (s *fakeTaskStream) Send(msg *pb.TaskSlots) error {
+	s.slots <- msg
+	return nil
+}
+
+func (s *fakeTaskStream) Recv() (*pb.Task, error) {
+	select {
+	case t := <-s.tasks:
+		return t, nil
+	case <-s.ctx.Done():
+		return nil, io.EOF
+	}
+}
+
+// submitted проверяет отправленный оркестратору исход задачи
+func submitted(taskID string, check func(*pb.SubmitResultRequest) bool) interface{} {
+	return mock.MatchedBy(func(req *pb.SubmitResultRequest) bool {
+		return req.TaskId == taskID && req.AgentId == "agent1" && check(req)
+	})
+}
+
+// runAgent запускает агента с одним воркером, пока не будет отправлен исход задачи
+func runAgent(t *testing.T, client *MockCalculatorClient, done chan struct{}) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan struct{})
+	go func() {
+		NewAgent("agent1", client, time.Minute).Run(ctx, 1)
+		close(finished)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("task outcome was not submitted")
+	}
+	cancel()
+	<-finished
 }
 
 func TestProcessTasks(t *testing.T) {
-	mockTM := new(MockTaskManager)
 	mockClient := new(MockCalculatorClient)
+	stream := newFakeTaskStream(&pb.Task{Id: "task1", Arg1: 2, Arg2: 3, Operation: "+"})
+	done := make(chan struct{})
 
-	task := &models.Task{
-		ID:        "task1",
-		Arg1:      2,
-		Arg2:      3,
-		Operation: "+",
-	}
+	mockClient.On("StreamTasks", mock.Anything).Return(stream, nil)
+	mockClient.On("SubmitResult", mock.Anything, submitted("task1", func(req *pb.SubmitResultRequest) bool {
+		return req.GetResult() == 5
+	})).Return(&pb.SubmitResultResponse{}, nil).Run(func(mock.Arguments) { close(done) })
+
+	runAgent(t, mockClient, done)
 
-	mockTM.On("GetNextTask").Return(task, nil)
-	mockTM.On("UpdateTaskResult", mock.Anything, "task1", 5.0).Return(nil)
-	mockClient.On("Calculate", mock.Anything, &server.CalculationRequest{
-		Arg1:      2,
-		Arg2:      3,
-		Operation: "+",
-	}).Return(&server.CalculationResponse{Result: 5}, nil)
+	mockClient.AssertExpectations(t)
+	first := <-stream.slots
+	assert.Equal(t, "agent1", first.AgentId)
+	assert.EqualValues(t, 1, first.FreeSlots)
+	require.Len(t, stream.slots, 1, "slot must be returned after the task is done")
+	assert.EqualValues(t, 1, (<-stream.slots).FreeSlots)
+}
+
+func TestProcessTasksFailure(t *testing.T) {
+	mockClient := new(MockCalculatorClient)
+	stream := newFakeTaskStream(&pb.Task{Id: "task1", Arg1: 2, Arg2: 0, Operation: "/"})
+	done := make(chan struct{})
 
-	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
-	defer cancel()
+	mockClient.On("StreamTasks", mock.Anything).Return(stream, nil)
+	mockClient.On("SubmitResult", mock.Anything, submitted("task1", func(req *pb.SubmitResultRequest) bool {
+		return req.GetError().GetPermanent()
+	})).Return(&pb.SubmitResultResponse{}, nil).Run(func(mock.Arguments) { close(done) })
 
-	processTasks(ctx, mockTM, mockClient, 1)
+	runAgent(t, mockClient, done)
 
-	mockTM.AssertExpectations(t)
 	mockClient.AssertExpectations(t)
-}
\ No newline at end of file
+}
+
+func TestHeartbeatCancelsLostTasks(t *testing.T) {
+	mockClient := new(MockCalculatorClient)
+	mockClient.On("Heartbeat", mock.Anything, &pb.HeartbeatRequest{AgentId: "agent1", TaskIds: []string{"task1"}}).
+		Return(&pb.HeartbeatResponse{LostTaskIds: []string{"task1"}}, nil)
+
+	agent := NewAgent("agent1", mockClient, 10*time.Millisecond)
+	taskCtx, cancel := context.WithCancel(context.Background())
+	agent.track("task1", cancel)
+
+	ctx, stop := context.WithTimeout(context.Background(), time.Second)
+	defer stop()
+	go agent.sendHeartbeats(ctx)
+
+	select {
+	case <-taskCtx.Done():
+	case <-ctx.Done():
+		t.Fatal("task was not canceled after its lease was lost")
+	}
+}